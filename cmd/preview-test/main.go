@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"plant-shutter-pi/pkg/camera"
+	"plant-shutter-pi/pkg/types"
 )
 
 // 在一个 main 里完成以下测试流程：
@@ -34,7 +35,7 @@ func main() {
 
 		// 1) 预览前拍照
 		fmt.Printf("[1/4] 预览前拍照: %dx%d...\n", *cw, *ch)
-		img1, err := ctrl.Capture(*cw, *ch)
+		img1, err := ctrl.Capture(types.CaptureSetting{Width: *cw, Height: *ch})
 		if err != nil {
 			fmt.Println("Capture(预览前) 失败:", err)
 			os.Exit(1)
@@ -56,7 +57,7 @@ func main() {
 
 		// 3) 预览进行时拍照
 		fmt.Printf("[3/4] 预览进行时拍照: %dx%d...\n", *cw, *ch)
-		img2, err := ctrl.Capture(*cw, *ch)
+		img2, err := ctrl.Capture(types.CaptureSetting{Width: *cw, Height: *ch})
 		if err != nil {
 			fmt.Println("Capture(预览中) 失败:", err)
 			os.Exit(1)
@@ -78,7 +79,7 @@ func main() {
 
 		// 4) 预览后拍照
 		fmt.Printf("[4/4] 预览后拍照: %dx%d...\n", *cw, *ch)
-		img3, err := ctrl.Capture(*cw, *ch)
+		img3, err := ctrl.Capture(types.CaptureSetting{Width: *cw, Height: *ch})
 		if err != nil {
 			fmt.Println("Capture(预览后) 失败:", err)
 			os.Exit(1)
@@ -1,22 +1,30 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
+	"encoding/base64"
+	"encoding/csv"
 	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"io/fs"
+	"mime"
 	"mime/multipart"
 	"net"
 	"net/http"
 	"net/textproto"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -25,19 +33,31 @@ import (
 	"github.com/beevik/ntp"
 	"github.com/dustin/go-humanize"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/goccy/go-json"
+	"github.com/pion/webrtc/v4"
 	"github.com/vincent-vinf/go-jsend"
 	"go.uber.org/zap"
 
 	"plant-shutter-pi/pkg/storage/project"
 	"plant-shutter-pi/pkg/types"
 
+	"plant-shutter-pi/pkg/archive"
 	"plant-shutter-pi/pkg/camera"
+	"plant-shutter-pi/pkg/exif"
+	"plant-shutter-pi/pkg/export"
+	"plant-shutter-pi/pkg/imaging"
+	"plant-shutter-pi/pkg/jobs"
 	"plant-shutter-pi/pkg/ov"
+	"plant-shutter-pi/pkg/overlay"
+	"plant-shutter-pi/pkg/rtc"
 	"plant-shutter-pi/pkg/schedule"
 	"plant-shutter-pi/pkg/storage"
 	"plant-shutter-pi/pkg/storage/consts"
+	"plant-shutter-pi/pkg/thumb"
 	"plant-shutter-pi/pkg/utils"
 	"plant-shutter-pi/pkg/utils/ps"
+	"plant-shutter-pi/pkg/video"
 	"plant-shutter-pi/pkg/webdav"
 )
 
@@ -46,6 +66,12 @@ const (
 	webDavShutdown = "shutdown"
 
 	runningProjectRouterKey = "running"
+
+	// maxPreviewInterval 自适应预览降速的下限为每 2 秒一帧
+	maxPreviewInterval = 2 * time.Second
+
+	// thumbWidth 照片列表中缩略图的宽度
+	thumbWidth = 320
 )
 
 //go:embed statics.zip
@@ -59,14 +85,20 @@ var (
 	devName    = flag.String("dev", "/dev/video0", "")
 	width      = flag.Int("width", 0, "")
 	height     = flag.Int("height", 0, "")
+	fontFile   = flag.String("font", "", "TTF/OTF font for image overlays, e.g. a CJK font")
+	thumbCache = flag.Int64("thumb-cache", 64, "max thumbnail cache size per project (MB)")
 
 	logger       *zap.SugaredLogger
 	webdavServer *webdav.Webdav
+	rtcServer    *rtc.Server
+	thumbs       *thumb.Cache
+	jobQueue     *jobs.Queue
+	// repairs 启动时修复的视频分段，按项目名保存
+	repairs map[string][]project.RepairResult
 
 	stg        *storage.Storage
-	dev        *camera.Camera
-	controller *camera.Controller
-	sch        *schedule.Scheduler
+	cameras    *camera.Registry
+	schedulers *schedule.Manager
 )
 
 func init() {
@@ -90,13 +122,26 @@ func main() {
 		logger.Fatal(err)
 	}
 
+	if *fontFile != "" {
+		if err = overlay.SetFont(*fontFile); err != nil {
+			logger.Fatal(err)
+		}
+	}
+
 	webdavServer = webdav.New(ctx, *webdavPort, *storageDir)
+	rtcServer = rtc.NewServer()
+	thumbs = thumb.New(*thumbCache << 20)
 
 	// init storage
 	stg, err = storage.New(*storageDir)
 	if err != nil {
 		logger.Fatal(err)
 	}
+	repairs = repairVideos()
+	jobQueue, err = jobs.New(ctx, path.Join(*storageDir, consts.DefaultJobsFile), runJob)
+	if err != nil {
+		logger.Fatal(err)
+	}
 
 	// init gin
 	r := gin.New()
@@ -115,6 +160,9 @@ func main() {
 
 	deviceRouter := apiRouter.Group("/device")
 	deviceRouter.GET("/realtime/video", realtimeVideo)
+	deviceRouter.POST("/realtime/webrtc", webrtcPreview)
+	deviceRouter.POST("/snapshot", snapshot)
+	deviceRouter.GET("/snapshot/:name", getSnapshot)
 	deviceRouter.PUT("/webdav", ctlWebdav)
 	deviceRouter.GET("/config", listConfig)
 	deviceRouter.PUT("/config", updateConfig)
@@ -123,6 +171,8 @@ func main() {
 	deviceRouter.GET("/disk", getDiskUsage)
 	deviceRouter.GET("/memory", getMemUsage)
 	deviceRouter.GET("/camera", getCameraStatus)
+	deviceRouter.GET("/cameras", listCameras)
+	deviceRouter.GET("/formats", listFormats)
 
 	projectRouter := apiRouter.Group("/project")
 	projectRouter.GET("/:name", getProject)
@@ -132,55 +182,129 @@ func main() {
 	projectRouter.PUT("", updateProject)
 	projectRouter.PUT("/:name/reset", resetProject)
 	projectRouter.DELETE("/:name", deleteProject)
+	projectRouter.POST("/:name/test-shot", testShot)
 
 	projectRouter.GET("/:name/image", listProjectImages)
 	projectRouter.GET("/:name/image/latest", projectLatestImage)
+	projectRouter.GET("/:name/image/archive", getImageArchive)
+	projectRouter.HEAD("/:name/image/archive", getImageArchive)
 	projectRouter.GET("/:name/image/:image", getProjectImage)
+	projectRouter.HEAD("/:name/image/:image", getProjectImage)
+	projectRouter.GET("/:name/image/:image/exif", getProjectImageExif)
 	projectRouter.DELETE("/:name/image/:image", deleteProjectImage)
 	projectRouter.DELETE("/:name/image", deleteProjectImages)
 
 	projectRouter.GET("/:name/video", listProjectVideos)
 	projectRouter.GET("/:name/video/:video", getProjectVideo)
+	projectRouter.HEAD("/:name/video/:video", getProjectVideo)
+	projectRouter.POST("/:name/video/concat", concatProjectVideos)
 	projectRouter.DELETE("/:name/video/:video", deleteProjectVideo)
 	projectRouter.DELETE("/:name/video", deleteProjectVideos)
 
+	projectRouter.GET("/:name/growth", getProjectGrowth)
+	projectRouter.POST("/:name/growth", analyzeProjectGrowth)
+
+	projectRouter.POST("/:name/export", createProjectExport)
+	projectRouter.GET("/:name/export", listProjectExports)
+	projectRouter.GET("/:name/export/:file", getProjectExport)
+	projectRouter.HEAD("/:name/export/:file", getProjectExport)
+	projectRouter.DELETE("/:name/export/:file", deleteProjectExport)
+
+	jobRouter := apiRouter.Group("/jobs")
+	jobRouter.GET("", listJobs)
+	jobRouter.GET("/:id", getJob)
+	jobRouter.POST("", createJob)
+	jobRouter.DELETE("/:id", cancelJob)
+	jobRouter.DELETE("", clearJobs)
+
 	ips, err := getLocalIPsWithPort(*port)
 	if err != nil {
 		logger.Fatal(err)
 	}
 	logger.Info("listen ", ips)
 	// init camera
-	if err = initDevice(ctx, *devName, *width, *height); err != nil {
-		logger.Error(fmt.Sprintf("camera %s is not ready, related functions will not be available, err: %s", *devName, err))
-	}
+	initDevice(ctx, *devName, *width, *height)
 
 	// init schedule
-	sch = schedule.New(ctx, controller)
+	schedulers = schedule.NewManager(ctx, jobQueue)
 
 	utils.ListenAndServe(ctx, r, *port)
 }
 
-func initDevice(ctx context.Context, devName string, w, h int) error {
-	dev = camera.New(ctx, devName)
-	dev.ResetSettings()
-	var err error
-	if w <= 0 || h <= 0 {
-		w, h, err = dev.GetMaxSize()
+// repairVideos 在开始拍摄前修复上次未正常关闭的视频分段
+func repairVideos() map[string][]project.RepairResult {
+	res := make(map[string][]project.RepairResult)
+	list, err := stg.ListProjects()
+	if err != nil {
+		logger.Errorf("repair videos: %s", err)
+		return res
+	}
+	for _, p := range list {
+		r, err := p.RepairVideos()
 		if err != nil {
-			return err
+			logger.Errorf("repair videos of %s: %s", p.Name, err)
+			continue
+		}
+		if len(r) > 0 {
+			res[p.Name] = r
 		}
 	}
-	consts.Width = w
-	consts.Height = h
-	logger.Infof("set pix format to %d*%d", w, h)
 
-	controller = camera.NewController(dev)
+	return res
+}
 
-	return nil
+// initDevice 枚举摄像头，设备的打开与掉线重连由各自的 supervisor 负责
+func initDevice(ctx context.Context, devName string, w, h int) {
+	cameras = camera.NewRegistry(ctx, devName, w, h, func(u *camera.Unit) error {
+		w, h := u.Size()
+		logger.Infof("camera %s: max pix format %d*%d", u.Info().ID, w, h)
+
+		return nil
+	})
+}
+
+// getUnit 按 id 查找摄像头，id 为空时使用默认摄像头；未找到时写入错误响应
+func getUnit(c *gin.Context, id string) (*camera.Unit, bool) {
+	u := cameras.Get(id)
+	if u == nil {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr(fmt.Sprintf("camera %s not found", id)))
+		return nil, false
+	}
+
+	return u, true
+}
+
+// getReadyUnit 按查询参数 camera 查找摄像头，并要求其已连接
+func getReadyUnit(c *gin.Context) (*camera.Unit, bool) {
+	u, ok := getUnit(c, c.Query("camera"))
+	if !ok || !cameraReady(c, u) {
+		return nil, false
+	}
+
+	return u, true
+}
+
+// cameraReady 设备不可用时写入错误响应并返回 false
+func cameraReady(c *gin.Context, u *camera.Unit) bool {
+	st := u.Supervisor.Status()
+	if st.State == camera.StateConnected {
+		return true
+	}
+	msg := fmt.Sprintf("camera %s is %s", st.Device, st.State)
+	if st.LastError != "" {
+		msg = fmt.Sprintf("%s, last error: %s", msg, st.LastError)
+	}
+	c.JSON(http.StatusServiceUnavailable, jsend.SimpleErr(msg))
+
+	return false
 }
 
 func listConfig(c *gin.Context) {
-	configs, err := dev.GetKnownCtrlConfigs()
+	u, ok := getReadyUnit(c)
+	if !ok {
+		return
+	}
+	configs, err := u.Camera.GetKnownCtrlConfigs()
 	if err != nil {
 		internalErr(c, err)
 		return
@@ -189,7 +313,11 @@ func listConfig(c *gin.Context) {
 }
 
 func updateConfig(c *gin.Context) {
-	if p := sch.GetProject(); p != nil {
+	u, ok := getReadyUnit(c)
+	if !ok {
+		return
+	}
+	if p := schedulers.Get(u).GetProject(); p != nil {
 		c.JSON(http.StatusBadRequest, jsend.SimpleErr(fmt.Sprintf("project %s is running", p.Name)))
 	}
 	configs := make([]ov.UpdateConfig, 0)
@@ -198,7 +326,7 @@ func updateConfig(c *gin.Context) {
 		return
 	}
 	for _, cfg := range configs {
-		if err = dev.SetControlValue(cfg.ID, cfg.Value); err != nil {
+		if err = u.Camera.SetControlValue(cfg.ID, cfg.Value); err != nil {
 			internalErr(c, err)
 			return
 		}
@@ -208,18 +336,22 @@ func updateConfig(c *gin.Context) {
 }
 
 func resetConfig(c *gin.Context) {
-	configs, err := dev.GetKnownCtrlConfigs()
+	u, ok := getReadyUnit(c)
+	if !ok {
+		return
+	}
+	configs, err := u.Camera.GetKnownCtrlConfigs()
 	if err != nil {
 		internalErr(c, err)
 		return
 	}
 	for _, cfg := range configs {
-		if err = dev.SetControlValue(cfg.ID, cfg.Default); err != nil {
+		if err = u.Camera.SetControlValue(cfg.ID, cfg.Default); err != nil {
 			internalErr(c, err)
 			return
 		}
 	}
-	configs, err = dev.GetKnownCtrlConfigs()
+	configs, err = u.Camera.GetKnownCtrlConfigs()
 	if err != nil {
 		internalErr(c, err)
 		return
@@ -284,15 +416,64 @@ func getMemUsage(c *gin.Context) {
 }
 
 func getCameraStatus(c *gin.Context) {
-	available := false
-	if dev != nil {
-		available = true
+	u, ok := getUnit(c, c.Query("camera"))
+	if !ok {
+		return
+	}
+	st := u.Supervisor.Status()
+	c.JSON(http.StatusOK, jsend.Success(map[string]any{
+		"available": st.State == camera.StateConnected,
+		"state":     st.State,
+		"device":    st.Device,
+		"lastError": st.LastError,
+		"retries":   st.Retries,
+		"since":     st.Since,
+		"viewers":   u.Preview.Viewers(),
+		"webrtc":    rtcServer.Sessions(),
+	}))
+}
+
+// listFormats 列出摄像头支持的像素格式、分辨率与可裁剪范围
+func listFormats(c *gin.Context) {
+	u, ok := getUnit(c, c.Query("camera"))
+	if !ok {
+		return
 	}
+	info := u.Info()
+
 	c.JSON(http.StatusOK, jsend.Success(map[string]any{
-		"available": available,
+		"formats":    info.Formats,
+		"cropBounds": info.CropBounds,
 	}))
 }
 
+func listCameras(c *gin.Context) {
+	defaultID := cameras.DefaultID()
+	res := make([]map[string]any, 0)
+	for _, u := range cameras.List() {
+		info := u.Info()
+		running := ""
+		if p := schedulers.Get(u).GetProject(); p != nil {
+			running = p.Name
+		}
+		res = append(res, map[string]any{
+			"id":      info.ID,
+			"path":    info.Path,
+			"card":    info.Card,
+			"driver":  info.Driver,
+			"busInfo": info.BusInfo,
+			"serial":  info.Serial,
+			"formats": info.Formats,
+			"default": info.ID == defaultID,
+			"status":  u.Supervisor.Status(),
+			"running": running,
+			"viewers": u.Preview.Viewers(),
+		})
+	}
+
+	c.JSON(http.StatusOK, jsend.Success(res))
+}
+
 func getProject(c *gin.Context) {
 	p, err := stg.GetProject(c.Param("name"))
 	if err != nil {
@@ -304,8 +485,7 @@ func getProject(c *gin.Context) {
 		return
 	}
 
-	runningP := sch.GetProject()
-	ovProject, err := fillOvProject(p, runningP)
+	ovProject, err := fillOvProject(p)
 	if err != nil {
 		internalErr(c, err)
 		return
@@ -316,7 +496,11 @@ func getProject(c *gin.Context) {
 }
 
 func getRunningProject(c *gin.Context) {
-	p := sch.GetProject()
+	u, ok := getUnit(c, c.Query("camera"))
+	if !ok {
+		return
+	}
+	p := schedulers.Get(u).GetProject()
 
 	c.JSON(http.StatusOK, jsend.Success(p))
 	return
@@ -329,9 +513,8 @@ func listProject(c *gin.Context) {
 		return
 	}
 	res := make([]ov.Project, 0)
-	runningP := sch.GetProject()
 	for _, p := range projects {
-		ovProject, err := fillOvProject(p, runningP)
+		ovProject, err := fillOvProject(p)
 		if err != nil {
 			internalErr(c, err)
 			return
@@ -343,7 +526,7 @@ func listProject(c *gin.Context) {
 	return
 }
 
-func fillOvProject(p, runningP *project.Project) (*ov.Project, error) {
+func fillOvProject(p *project.Project) (*ov.Project, error) {
 	usage, err := ps.DirDiskUsage(p.GetRootPath())
 	if err != nil {
 		return nil, err
@@ -355,12 +538,11 @@ func fillOvProject(p, runningP *project.Project) (*ov.Project, error) {
 	var o ov.Project
 	o.Project = p
 	o.DiskUsage = humanize.Bytes(uint64(usage))
-	if runningP != nil && runningP.Name == p.Name {
-		o.Running = true
-	}
+	o.Running = schedulers.Find(p.Name) != nil || viewScheduler(p) != nil
 	o.StartedAt = info.StartedAt
 	o.EndedAt = info.EndedAt
 	o.ImageTotal = info.MaxNumber
+	o.Skipped = info.Skipped
 	if o.StartedAt != nil && o.EndedAt != nil {
 		duration := o.EndedAt.Sub(*o.StartedAt)
 		hours := int(duration.Hours())
@@ -373,6 +555,15 @@ func fillOvProject(p, runningP *project.Project) (*ov.Project, error) {
 	return &o, nil
 }
 
+// viewScheduler 返回派生项目 p 的父项目所在的调度器，p 不是派生项目或父项目未运行时返回 nil
+func viewScheduler(p *project.Project) *schedule.Scheduler {
+	if !p.IsView() {
+		return nil
+	}
+
+	return schedulers.Find(p.Parent)
+}
+
 func createProject(c *gin.Context) {
 	var p ov.NewProject
 	err := c.Bind(&p)
@@ -386,7 +577,8 @@ func createProject(c *gin.Context) {
 	if *p.Interval < consts.MinInterval {
 		*p.Interval = consts.MinInterval
 	}
-	if p.Name == runningProjectRouterKey {
+	// snapshots 目录与项目目录位于同一层
+	if p.Name == runningProjectRouterKey || p.Name == consts.DefaultSnapshotsDir {
 		c.JSON(http.StatusBadRequest, jsend.SimpleErr(fmt.Sprintf("project name cannot be %s", p.Name)))
 		return
 	}
@@ -411,11 +603,76 @@ func createProject(c *gin.Context) {
 			PreviewVideoLength: 15,
 		}
 	}
-	pj, err = stg.NewProject(p.Name, p.Info, *p.Interval, make(types.CameraSettings), *p.Video)
+	if p.Device != "" && cameras.Get(p.Device) == nil {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr(fmt.Sprintf("camera %s not found", p.Device)))
+		return
+	}
+	if p.Parent != "" {
+		parent, err := stg.GetProject(p.Parent)
+		if err != nil {
+			internalErr(c, err)
+			return
+		}
+		if parent == nil {
+			c.JSON(http.StatusBadRequest, jsend.SimpleErr(fmt.Sprintf("parent project %s not found", p.Parent)))
+			return
+		}
+		if parent.IsView() {
+			c.JSON(http.StatusBadRequest, jsend.SimpleErr(fmt.Sprintf("project %s is a view and cannot be a parent", p.Parent)))
+			return
+		}
+		if p.View == nil || p.View.Crop.Empty() {
+			c.JSON(http.StatusBadRequest, jsend.SimpleErr("view crop is required"))
+			return
+		}
+	}
+	if p.Overlay == nil {
+		p.Overlay = &types.OverlaySetting{}
+	}
+	if _, err = overlay.ParseColor(p.Overlay.Color); err != nil {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+		return
+	}
+	if p.Filter == nil {
+		p.Filter = &types.FilterSetting{}
+	}
+	if p.Growth == nil {
+		p.Growth = &types.GrowthSetting{}
+	}
+	if err = checkGrowthSetting(p.Growth); err != nil {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+		return
+	}
+	if p.Calibration == nil {
+		p.Calibration = &types.CalibrationSetting{}
+	}
+	if p.Calibration.Enable && p.Calibration.Patch.Empty() {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr("calibration patch is required"))
+		return
+	}
+	pj, err = stg.NewProject(&project.Project{
+		Name:        p.Name,
+		Info:        p.Info,
+		Interval:    *p.Interval,
+		Camera:      make(types.CameraSettings),
+		Video:       *p.Video,
+		Device:      p.Device,
+		Capture:     p.Capture,
+		Parent:      p.Parent,
+		View:        p.View,
+		Location:    p.Location,
+		Overlay:     *p.Overlay,
+		Filter:      *p.Filter,
+		Growth:      *p.Growth,
+		Calibration: *p.Calibration,
+	})
 	if err != nil {
 		internalErr(c, err)
 		return
 	}
+	if sch := viewScheduler(pj); sch != nil {
+		sch.AddView(pj)
+	}
 
 	c.JSON(http.StatusOK, jsend.Success(pj))
 	return
@@ -437,6 +694,10 @@ func updateProject(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, jsend.SimpleErr("project does not exist"))
 		return
 	}
+	if p.Running != nil && pj.IsView() {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr(fmt.Sprintf("view %s runs with its parent project %s", pj.Name, pj.Parent)))
+		return
+	}
 
 	if p.Interval != nil {
 		if *p.Interval < consts.MinInterval {
@@ -448,15 +709,41 @@ func updateProject(c *gin.Context) {
 	if p.Info != nil {
 		pj.Info = *p.Info
 	}
+	if p.Location != nil {
+		pj.Location = p.Location
+	}
+	if p.Overlay != nil {
+		if _, err = overlay.ParseColor(p.Overlay.Color); err != nil {
+			c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+			return
+		}
+		pj.Overlay = *p.Overlay
+	}
+	if p.Filter != nil {
+		pj.Filter = *p.Filter
+	}
+	if p.Growth != nil {
+		if err = checkGrowthSetting(p.Growth); err != nil {
+			c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+			return
+		}
+		pj.Growth = *p.Growth
+	}
+	if p.Calibration != nil {
+		if p.Calibration.Enable && p.Calibration.Patch.Empty() {
+			c.JSON(http.StatusBadRequest, jsend.SimpleErr("calibration patch is required"))
+			return
+		}
+		pj.Calibration = *p.Calibration
+	}
 
-	if p.Camera != nil || p.Video != nil {
-		runningP := sch.GetProject()
+	if p.Camera != nil || p.Video != nil || p.Device != nil || p.Capture != nil || p.View != nil {
 		cleaned, err := pj.Cleaned()
 		if err != nil {
 			internalErr(c, err)
 			return
 		}
-		if (runningP != nil && runningP.Name == pj.Name) || !cleaned {
+		if schedulers.Find(pj.Name) != nil || viewScheduler(pj) != nil || !cleaned {
 			c.JSON(http.StatusBadRequest, jsend.SimpleErr(fmt.Sprintf("project %s has been run, please reset first", pj.Name)))
 			return
 		}
@@ -464,8 +751,33 @@ func updateProject(c *gin.Context) {
 	if p.Video != nil {
 		pj.Video = *p.Video
 	}
+	if p.Device != nil {
+		if cameras.Get(*p.Device) == nil {
+			c.JSON(http.StatusBadRequest, jsend.SimpleErr(fmt.Sprintf("camera %s not found", *p.Device)))
+			return
+		}
+		pj.Device = *p.Device
+	}
+	if p.Capture != nil {
+		pj.Capture = *p.Capture
+	}
+	if p.View != nil {
+		if !pj.IsView() {
+			c.JSON(http.StatusBadRequest, jsend.SimpleErr(fmt.Sprintf("project %s is not a view", pj.Name)))
+			return
+		}
+		if p.View.Crop.Empty() {
+			c.JSON(http.StatusBadRequest, jsend.SimpleErr("view crop is required"))
+			return
+		}
+		pj.View = p.View
+	}
 	if p.Camera != nil && *p.Camera {
-		setting, err := dev.GetKnownCtrlSettings()
+		u, ok := getUnit(c, pj.Device)
+		if !ok || !cameraReady(c, u) {
+			return
+		}
+		setting, err := u.Camera.GetKnownCtrlSettings()
 		if err != nil {
 			internalErr(c, err)
 			return
@@ -479,15 +791,25 @@ func updateProject(c *gin.Context) {
 		return
 	}
 	if p.Running != nil {
+		u, ok := getUnit(c, pj.Device)
+		if !ok {
+			return
+		}
+		sch := schedulers.Get(u)
 		runningP := sch.GetProject()
 		if runningP != nil && runningP.Name != p.Name {
 			c.JSON(http.StatusBadRequest, jsend.SimpleErr(fmt.Sprintf("project %s is running, please stop first", runningP.Name)))
 			return
 		}
 		if *p.Running {
+			views, err := stg.ListViews(pj.Name)
+			if err != nil {
+				internalErr(c, err)
+				return
+			}
 			logger.Info("restore camera settings")
-			dev.UpdateSettings(pj.Camera)
-			sch.Begin(pj)
+			u.Camera.UpdateSettings(pj.Camera)
+			sch.Begin(pj, views)
 		} else {
 			sch.Stop()
 		}
@@ -506,8 +828,10 @@ func resetProject(c *gin.Context) {
 		c.JSON(http.StatusNotFound, jsend.SimpleErr("project not found"))
 		return
 	}
-	if pj := sch.GetProject(); pj != nil && pj.Name == p.Name {
-		sch.Stop()
+	schedulers.Stop(p.Name)
+	sch := viewScheduler(p)
+	if sch != nil {
+		sch.RemoveView(p.Name)
 	}
 
 	if err = stg.DeleteProject(p.Name); err != nil {
@@ -515,11 +839,14 @@ func resetProject(c *gin.Context) {
 		return
 	}
 
-	p, err = stg.NewProject(p.Name, p.Info, p.Interval, p.Camera, p.Video)
+	p, err = stg.NewProject(p)
 	if err != nil {
 		internalErr(c, err)
 		return
 	}
+	if sch != nil {
+		sch.AddView(p)
+	}
 
 	c.JSON(http.StatusOK, jsend.Success(p))
 }
@@ -536,8 +863,22 @@ func deleteProject(c *gin.Context) {
 		c.JSON(http.StatusOK, jsend.SimpleErr("project does not exist"))
 		return
 	}
-	if p := sch.GetProject(); p != nil && p.Name == pj.Name {
-		sch.Stop()
+	views, err := stg.ListViews(pj.Name)
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	if len(views) > 0 {
+		names := make([]string, 0, len(views))
+		for _, v := range views {
+			names = append(names, v.Name)
+		}
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr(fmt.Sprintf("project %s has views %s, please delete them first", pj.Name, strings.Join(names, ", "))))
+		return
+	}
+	schedulers.Stop(pj.Name)
+	if sch := viewScheduler(pj); sch != nil {
+		sch.RemoveView(pj.Name)
 	}
 	if err = stg.DeleteProject(name); err != nil {
 		internalErr(c, err)
@@ -548,6 +889,85 @@ func deleteProject(c *gin.Context) {
 	return
 }
 
+// testShot 用项目保存的（或请求中提议的）设置试拍一张，拍完后恢复设备状态，照片不计入项目
+func testShot(c *gin.Context) {
+	var req ov.TestShot
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+			return
+		}
+	}
+	pj, err := stg.GetProject(c.Param("name"))
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	if pj == nil {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr("project not found"))
+		return
+	}
+	// 派生项目使用父项目的拍摄设置，再按派生设置裁剪
+	src := pj
+	if pj.IsView() {
+		if src, err = stg.GetProject(pj.Parent); err != nil {
+			internalErr(c, err)
+			return
+		}
+		if src == nil {
+			c.JSON(http.StatusBadRequest, jsend.SimpleErr(fmt.Sprintf("parent project %s not found", pj.Parent)))
+			return
+		}
+	}
+	controls, setting, view := src.Camera, src.Capture, pj.View
+	if req.Camera != nil {
+		controls = *req.Camera
+	}
+	if req.Capture != nil {
+		setting = *req.Capture
+	}
+	if req.View != nil && pj.IsView() {
+		view = req.View
+	}
+
+	u, ok := getUnit(c, src.Device)
+	if !ok || !cameraReady(c, u) {
+		return
+	}
+	frame, used, err := captureOnce(u, setting, controls)
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	if pj.IsView() {
+		img, err := imaging.Decode(frame)
+		if err != nil {
+			internalErr(c, err)
+			return
+		}
+		if frame, err = project.RenderView(img, view); err != nil {
+			c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+			return
+		}
+	}
+	w, h, err := video.FrameSize(frame)
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsend.Success(map[string]any{
+		"image":   "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(frame),
+		"width":   w,
+		"height":  h,
+		"device":  u.Info().ID,
+		"capture": u.CaptureSetting(setting),
+		"camera":  used,
+		"view":    view,
+		"takenAt": time.Now(),
+	}))
+}
+
 func projectLatestImage(c *gin.Context) {
 	p, err := stg.GetProject(c.Param("name"))
 	if err != nil {
@@ -567,6 +987,86 @@ func projectLatestImage(c *gin.Context) {
 	c.Writer.Write(image)
 }
 
+// getImageArchive 将筛选出的照片打包为不压缩的 zip 边生成边下载。
+// 大小可预先得知，支持 Range 断点续传，也可按大小分为多个压缩包依次下载。
+func getImageArchive(c *gin.Context) {
+	p, err := stg.GetProject(c.Param("name"))
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	if p == nil {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr("project not found"))
+		return
+	}
+	var req ov.Archive
+	if err = c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+		return
+	}
+	images, err := p.FilterImages(req.ImageFilter)
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	if len(images) == 0 {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr("no images selected"))
+		return
+	}
+	name := p.Name
+	if req.PartSize > 0 {
+		parts := splitParts(images, int64(req.PartSize)<<20)
+		if req.Part >= len(parts) {
+			c.JSON(http.StatusBadRequest, jsend.SimpleErr(fmt.Sprintf("part %d out of range, %d parts", req.Part, len(parts))))
+			return
+		}
+		c.Header("X-Archive-Parts", strconv.Itoa(len(parts)))
+		images = parts[req.Part]
+		name = fmt.Sprintf("%s-part%d", p.Name, req.Part+1)
+	}
+	entries := make([]archive.Entry, len(images))
+	var modified time.Time
+	h := fnv.New64a()
+	for i, info := range images {
+		entries[i] = archive.Entry{
+			Name:     info.Name(),
+			Path:     p.GetImagePath(info.Name()),
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+		_, _ = fmt.Fprintf(h, "%s|%d|%d\n", info.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	z := archive.New(entries)
+	defer z.Close()
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".zip"}))
+	// 照片不变时 ETag 不变，续传时可用 If-Range 校验
+	c.Header("ETag", fmt.Sprintf(`"%x"`, h.Sum64()))
+	http.ServeContent(c.Writer, c.Request, name+".zip", modified, io.NewSectionReader(z, 0, z.Size()))
+}
+
+// splitParts 按顺序将照片分组，每组总大小不超过 size，单张超过 size 的照片单独一组
+func splitParts(images []fs.FileInfo, size int64) [][]fs.FileInfo {
+	var res [][]fs.FileInfo
+	var cur []fs.FileInfo
+	var total int64
+	for _, info := range images {
+		if len(cur) > 0 && total+info.Size() > size {
+			res = append(res, cur)
+			cur, total = nil, 0
+		}
+		cur = append(cur, info)
+		total += info.Size()
+	}
+
+	return append(res, cur)
+}
+
+// getProjectImage 返回照片或指定尺寸的缩略图，支持 Range 与缓存校验
 func getProjectImage(c *gin.Context) {
 	p, err := stg.GetProject(c.Param("name"))
 	if err != nil {
@@ -577,13 +1077,65 @@ func getProjectImage(c *gin.Context) {
 		c.JSON(http.StatusNotFound, jsend.SimpleErr("project not found"))
 		return
 	}
-	image, err := p.GetImage(c.Param("image"))
+	name := c.Param("image")
+	var rendition ov.Rendition
+	if err = c.ShouldBindQuery(&rendition); err != nil {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+		return
+	}
+	if name != path.Base(name) {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr("invalid image name"))
+		return
+	}
+	if rendition.Width <= 0 && rendition.Height <= 0 {
+		serveFile(c, p.GetImagePath(name), false)
+		return
+	}
+	info, err := os.Stat(p.GetImagePath(name))
+	if err != nil {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr("image not found"))
+		return
+	}
+	image, err := thumbs.Get(p.GetThumbDirPath(), name, rendition.Width, rendition.Height, rendition.Quality, func() ([]byte, error) {
+		return p.GetImage(name)
+	})
 	if err != nil {
 		internalErr(c, err)
 		return
 	}
+	// 缩略图随原图变化，ETag 由原图与尺寸计算
+	c.Header("ETag", fmt.Sprintf(`"%x-%x-%dx%d-q%d"`, info.Size(), info.ModTime().UnixNano(), rendition.Width, rendition.Height, rendition.Quality))
 	c.Header("Content-Type", "image/jpeg")
-	c.Writer.Write(image)
+	http.ServeContent(c.Writer, c.Request, name, info.ModTime(), bytes.NewReader(image))
+}
+
+// getProjectImageExif 读取照片中的 EXIF 信息
+func getProjectImageExif(c *gin.Context) {
+	p, err := stg.GetProject(c.Param("name"))
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	if p == nil {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr("project not found"))
+		return
+	}
+	image, err := p.GetImage(c.Param("image"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr(err.Error()))
+		return
+	}
+	res, err := exif.Read(image)
+	if errors.Is(err, exif.ErrNotFound) {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr(err.Error()))
+		return
+	}
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsend.Success(res))
 }
 
 func deleteProjectImage(c *gin.Context) {
@@ -602,6 +1154,7 @@ func deleteProjectImage(c *gin.Context) {
 		internalErr(c, err)
 		return
 	}
+	thumbs.Remove(p.GetThumbDirPath(), name)
 
 	c.JSON(http.StatusOK, jsend.Success(fmt.Sprintf("remove image %s success", name)))
 }
@@ -650,6 +1203,9 @@ func listProjectImages(c *gin.Context) {
 	page, _ := strconv.Atoi(c.Query("page"))
 	pageSize, _ := strconv.Atoi(c.Query("page_size"))
 	subImages, prev, next := getPage(list, page, pageSize)
+	for i := range subImages {
+		subImages[i].Thumbnail = fmt.Sprintf("/api/project/%s/image/%s?w=%d", url.PathEscape(p.Name), url.PathEscape(subImages[i].Name), thumbWidth)
+	}
 	c.JSON(http.StatusOK, jsend.Success(map[string]any{
 		"page":      page,
 		"pageSize":  pageSize,
@@ -661,6 +1217,7 @@ func listProjectImages(c *gin.Context) {
 	}))
 }
 
+// getProjectVideo 下载视频，inline=true 时供浏览器直接播放；支持 Range 断点续传
 func getProjectVideo(c *gin.Context) {
 	p, err := stg.GetProject(c.Param("name"))
 	if err != nil {
@@ -672,13 +1229,16 @@ func getProjectVideo(c *gin.Context) {
 		return
 	}
 	videoName := c.Param("video")
-	videoPath := p.GetVideoPath(videoName)
-	c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", videoName))
-	c.Writer.Header().Set("Content-Type", "application/octet-stream")
-	c.File(videoPath)
+	if videoName != path.Base(videoName) {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr("invalid video name"))
+		return
+	}
+	inline, _ := strconv.ParseBool(c.Query("inline"))
+	serveFile(c, p.GetVideoPath(videoName), !inline)
 }
 
-func deleteProjectVideo(c *gin.Context) {
+// concatProjectVideos 在后台将视频分段无损拼接为一个视频，保存到项目的 exports 目录
+func concatProjectVideos(c *gin.Context) {
 	p, err := stg.GetProject(c.Param("name"))
 	if err != nil {
 		internalErr(c, err)
@@ -688,23 +1248,90 @@ func deleteProjectVideo(c *gin.Context) {
 		c.JSON(http.StatusNotFound, jsend.SimpleErr("project not found"))
 		return
 	}
-	videoName := c.Param("video")
-	videoPath := p.GetVideoPath(videoName)
-	if err = os.Remove(videoPath); err != nil {
-		internalErr(c, err)
+	var req ov.ConcatVideos
+	if err = c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
 		return
 	}
 
-	c.JSON(http.StatusOK, jsend.Success(fmt.Sprintf("remove video %s success", videoName)))
+	submitJob(c, p, jobConcat, 0, &req)
 }
 
-func deleteProjectVideos(c *gin.Context) {
-	p, err := stg.GetProject(c.Param("name"))
-	if err != nil {
-		internalErr(c, err)
-		return
+// concatSources 返回要拼接的视频路径，未指定时为除完整视频外的全部分段
+func concatSources(p *project.Project, req *ov.ConcatVideos) ([]string, error) {
+	names := req.Videos
+	if len(names) == 0 {
+		err := p.ListVideos(func(info fs.FileInfo) error {
+			if !p.IsFullVideo(info.Name()) {
+				names = append(names, info.Name())
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
-	if p == nil {
+	if len(names) == 0 {
+		return nil, errors.New("no videos selected")
+	}
+	srcs := make([]string, len(names))
+	for i, name := range names {
+		if name != path.Base(name) || !strings.HasSuffix(name, consts.DefaultVideoExt) {
+			return nil, fmt.Errorf("invalid video name %s", name)
+		}
+		srcs[i] = p.GetVideoPath(name)
+		if _, err := os.Stat(srcs[i]); err != nil {
+			return nil, fmt.Errorf("video %s not found", name)
+		}
+	}
+
+	return srcs, nil
+}
+
+func runConcat(ctx context.Context, p *project.Project, req *ov.ConcatVideos, output string, progress func(float64)) error {
+	srcs, err := concatSources(p, req)
+	if err != nil {
+		return err
+	}
+	tmp := path.Join(path.Dir(output), "."+path.Base(output))
+	defer os.Remove(tmp)
+	err = video.Concat(ctx, tmp, srcs, func(done, total int) {
+		progress(float64(done) / float64(total))
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, output)
+}
+
+func deleteProjectVideo(c *gin.Context) {
+	p, err := stg.GetProject(c.Param("name"))
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	if p == nil {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr("project not found"))
+		return
+	}
+	videoName := c.Param("video")
+	videoPath := p.GetVideoPath(videoName)
+	if err = os.Remove(videoPath); err != nil {
+		internalErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsend.Success(fmt.Sprintf("remove video %s success", videoName)))
+}
+
+func deleteProjectVideos(c *gin.Context) {
+	p, err := stg.GetProject(c.Param("name"))
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	if p == nil {
 		c.JSON(http.StatusNotFound, jsend.SimpleErr("project not found"))
 		return
 	}
@@ -717,6 +1344,480 @@ func deleteProjectVideos(c *gin.Context) {
 	c.JSON(http.StatusOK, jsend.Success("remove videos success"))
 }
 
+// checkGrowthSetting 检查生长分析区域
+func checkGrowthSetting(s *types.GrowthSetting) error {
+	names := make(map[string]struct{}, len(s.ROIs))
+	for _, roi := range s.ROIs {
+		if roi.Rect.Empty() {
+			return fmt.Errorf("growth roi %s is empty", roi.Name)
+		}
+		if _, ok := names[roi.Name]; ok {
+			return fmt.Errorf("duplicate growth roi %s", roi.Name)
+		}
+		names[roi.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+// getProjectGrowth 返回照片索引中的生长测量时间序列，format=csv 时导出 CSV，roi 可筛选区域
+func getProjectGrowth(c *gin.Context) {
+	p, err := stg.GetProject(c.Param("name"))
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	if p == nil {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr("project not found"))
+		return
+	}
+	roi := c.Query("roi")
+	points := make([]ov.GrowthPoint, 0)
+	err = p.LoadIndex(func(r *project.ImageRecord) error {
+		for _, g := range r.Growth {
+			if roi != "" && g.ROI != roi {
+				continue
+			}
+			points = append(points, ov.GrowthPoint{
+				Time:   r.Time,
+				Image:  r.Name,
+				Number: r.Number,
+				Result: g,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	if c.Query("format") != "csv" {
+		c.JSON(http.StatusOK, jsend.Success(points))
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s-growth.csv", p.Name))
+	c.Header("Content-Type", "text/csv")
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write([]string{"time", "image", "number", "roi", "leafArea", "height", "width", "coverage"})
+	for _, pt := range points {
+		_ = w.Write([]string{
+			pt.Time.Format(time.RFC3339),
+			pt.Image,
+			strconv.Itoa(pt.Number),
+			pt.ROI,
+			strconv.Itoa(pt.LeafArea),
+			strconv.Itoa(pt.Height),
+			strconv.Itoa(pt.Width),
+			strconv.FormatFloat(pt.Coverage, 'f', 4, 64),
+		})
+	}
+	w.Flush()
+}
+
+// analyzeProjectGrowth 在后台按当前设置重新分析所有照片
+func analyzeProjectGrowth(c *gin.Context) {
+	p, err := stg.GetProject(c.Param("name"))
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	if p == nil {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr("project not found"))
+		return
+	}
+	submitJob(c, p, jobGrowth, 0, nil)
+}
+
+// createProjectExport 在后台生成联系表、动图或视频，保存到项目的 exports 目录
+func createProjectExport(c *gin.Context) {
+	p, err := stg.GetProject(c.Param("name"))
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	if p == nil {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr("project not found"))
+		return
+	}
+	var req ov.Export
+	if err = c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+		return
+	}
+
+	submitJob(c, p, jobExport, 0, &req)
+}
+
+// selectImages 返回照片列表与按 req 选中的照片序号
+func selectImages(p *project.Project, req *ov.Export) ([]fs.FileInfo, []int, error) {
+	var images []fs.FileInfo
+	err := p.ListImages(func(info fs.FileInfo) error {
+		images = append(images, info)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	limit := export.MaxAnimationFrames
+	switch req.Type {
+	case export.KindContactSheet:
+		limit = export.MaxSheetFrames
+	case export.KindVideo:
+		limit = 0
+	}
+	indexes := export.Select(len(images), req.Start, req.End, req.Every, limit)
+	if len(indexes) == 0 {
+		return nil, nil, errors.New("no images selected")
+	}
+
+	return images, indexes, nil
+}
+
+func runExport(ctx context.Context, p *project.Project, req *ov.Export, output string, progress func(float64)) error {
+	images, indexes, err := selectImages(p, req)
+	if err != nil {
+		return err
+	}
+	frames := make([]export.Frame, len(indexes))
+	for i, j := range indexes {
+		name := images[j].Name()
+		frames[i] = export.Frame{
+			Name: name,
+			Time: images[j].ModTime(),
+			Load: func() ([]byte, error) {
+				// 任务取消后读取照片失败，导出随之结束
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+				return p.GetImage(name)
+			},
+		}
+	}
+	opts := export.Options{
+		Width:   req.Width,
+		Columns: req.Columns,
+		FPS:     req.FPS,
+		Quality: req.Quality,
+	}
+	render := video.RenderOptions{
+		FPS:       req.FPS,
+		Width:     req.Width,
+		Quality:   req.Quality,
+		Deflicker: p.Video.Deflicker,
+	}
+	if render.FPS == 0 {
+		render.FPS = p.Video.FPS
+	}
+	if req.Deflicker != nil {
+		render.Deflicker = *req.Deflicker
+	}
+	render.Blend = req.Blend
+	// 变速区间换算为选中照片中的序号
+	for _, ramp := range req.Ramps {
+		render.Ramps = append(render.Ramps, types.SpeedRamp{
+			Start: sort.SearchInts(indexes, ramp.Start),
+			End:   sort.SearchInts(indexes, ramp.End),
+			Speed: ramp.Speed,
+		})
+	}
+
+	return renderExport(req.Type, output, frames, opts, render, progress)
+}
+
+// renderExport 先写入临时文件，完成后再重命名，避免下载到不完整的文件
+func renderExport(kind, output string, frames []export.Frame, opts export.Options, render video.RenderOptions, report func(float64)) error {
+	if err := utils.MkdirAll(path.Dir(output)); err != nil {
+		return err
+	}
+	tmp := path.Join(path.Dir(output), "."+path.Base(output))
+	defer os.Remove(tmp)
+	progress := func(done int) {
+		report(float64(done) / float64(len(frames)))
+	}
+
+	var err error
+	switch kind {
+	case export.KindContactSheet:
+		var data []byte
+		if data, err = export.ContactSheet(frames, opts, progress); err == nil {
+			err = os.WriteFile(tmp, data, consts.DefaultFilePerm)
+		}
+	case export.KindGIF:
+		var f *os.File
+		if f, err = os.Create(tmp); err != nil {
+			return err
+		}
+		err = export.GIF(f, frames, opts, progress)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	case export.KindWebP:
+		err = export.WebP(tmp, frames, opts, progress)
+	case export.KindVideo:
+		err = video.Render(tmp, len(frames), func(i int) ([]byte, error) {
+			return frames[i].Load()
+		}, render, func(done, total int) {
+			report(float64(done) / float64(total))
+		})
+	default:
+		err = fmt.Errorf("unknown export type %s", kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, output)
+}
+
+func listProjectExports(c *gin.Context) {
+	p, err := stg.GetProject(c.Param("name"))
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	if p == nil {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr("project not found"))
+		return
+	}
+	list := make([]types.File, 0)
+	err = p.ListExports(func(info fs.FileInfo) error {
+		list = append(list, infoToFile(info))
+		return nil
+	})
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsend.Success(map[string]any{
+		"files": list,
+		"jobs":  jobQueue.List(p.Name),
+	}))
+}
+
+func getProjectExport(c *gin.Context) {
+	p, err := stg.GetProject(c.Param("name"))
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	if p == nil {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr("project not found"))
+		return
+	}
+	exportPath, err := p.GetExportPath(c.Param("file"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+		return
+	}
+	inline, _ := strconv.ParseBool(c.Query("inline"))
+	serveFile(c, exportPath, !inline)
+}
+
+func deleteProjectExport(c *gin.Context) {
+	p, err := stg.GetProject(c.Param("name"))
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	if p == nil {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr("project not found"))
+		return
+	}
+	name := c.Param("file")
+	exportPath, err := p.GetExportPath(name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+		return
+	}
+	if err = os.Remove(exportPath); err != nil {
+		internalErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsend.Success(fmt.Sprintf("remove export %s success", name)))
+}
+
+const (
+	jobExport     = "export"
+	jobConcat     = "concat"
+	jobGrowth     = "growth"
+	jobThumbnails = "thumbnails"
+)
+
+func listJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, jsend.Success(jobQueue.List(c.Query("project"))))
+}
+
+func getJob(c *gin.Context) {
+	job, ok := jobQueue.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr("job not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, jsend.Success(job))
+}
+
+func createJob(c *gin.Context) {
+	var req ov.Job
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+		return
+	}
+	p, err := stg.GetProject(req.Project)
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	if p == nil {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr("project not found"))
+		return
+	}
+	params, err := decodeJobParams(req.Type, req.Params)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+		return
+	}
+
+	submitJob(c, p, req.Type, req.Priority, params)
+}
+
+// cancelJob 取消等待中或正在运行的任务，已结束的任务从列表中移除
+func cancelJob(c *gin.Context) {
+	job, err := jobQueue.Cancel(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, jsend.Success(job))
+}
+
+// clearJobs 移除所有已结束的任务
+func clearJobs(c *gin.Context) {
+	jobQueue.Clear()
+
+	c.JSON(http.StatusOK, jsend.Success("clear finished jobs success"))
+}
+
+// submitJob 校验参数并加入任务队列，导出类任务在提交时确定输出文件名
+func submitJob(c *gin.Context, p *project.Project, typ string, priority int, params any) {
+	var output string
+	switch req := params.(type) {
+	case *ov.Export:
+		if _, _, err := selectImages(p, req); err != nil {
+			c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+			return
+		}
+		ext, err := export.Ext(req.Type)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+			return
+		}
+		output = fmt.Sprintf("%s-%s-%s%s", p.Name, req.Type, time.Now().Format("20060102-150405"), ext)
+	case *ov.ConcatVideos:
+		if _, err := concatSources(p, req); err != nil {
+			c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+			return
+		}
+		output = fmt.Sprintf("%s-concat-%s%s", p.Name, time.Now().Format("20060102-150405"), consts.DefaultVideoExt)
+	}
+	if output != "" {
+		if _, err := p.GetExportPath(output); err != nil {
+			internalErr(c, err)
+			return
+		}
+	}
+	job, err := jobQueue.Add(typ, p.Name, priority, params, output)
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsend.Success(job))
+}
+
+// decodeJobParams 按任务类型解析并校验参数，没有参数的任务返回 nil
+func decodeJobParams(typ string, data []byte) (any, error) {
+	var params any
+	switch typ {
+	case jobExport:
+		params = &ov.Export{}
+	case jobConcat:
+		params = &ov.ConcatVideos{}
+	default:
+		return nil, nil
+	}
+	if len(data) > 0 && string(data) != "null" {
+		if err := json.Unmarshal(data, params); err != nil {
+			return nil, err
+		}
+	}
+
+	return params, binding.Validator.ValidateStruct(params)
+}
+
+// runJob 由任务队列调用，按提交时保存的参数执行任务
+func runJob(ctx context.Context, job jobs.Job, progress func(float64)) error {
+	p, err := stg.GetProject(job.Project)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return fmt.Errorf("project %s not found", job.Project)
+	}
+	params, err := decodeJobParams(job.Type, job.Params)
+	if err != nil {
+		return err
+	}
+	var output string
+	if job.Output != "" {
+		if output, err = p.GetExportPath(job.Output); err != nil {
+			return err
+		}
+	}
+	switch job.Type {
+	case jobExport:
+		return runExport(ctx, p, params.(*ov.Export), output, progress)
+	case jobConcat:
+		return runConcat(ctx, p, params.(*ov.ConcatVideos), output, progress)
+	case jobGrowth:
+		return p.AnalyzeGrowth(ctx, progress)
+	case jobThumbnails:
+		return renderThumbnails(ctx, p, progress)
+	}
+
+	return fmt.Errorf("unknown job type %s", job.Type)
+}
+
+// renderThumbnails 预先生成照片列表使用的缩略图
+func renderThumbnails(ctx context.Context, p *project.Project, progress func(float64)) error {
+	var names []string
+	err := p.ListImages(func(info fs.FileInfo) error {
+		names = append(names, info.Name())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, name := range names {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		_, err = thumbs.Get(p.GetThumbDirPath(), name, thumbWidth, 0, 0, func() ([]byte, error) {
+			return p.GetImage(name)
+		})
+		if err != nil {
+			return err
+		}
+		progress(float64(i+1) / float64(len(names)))
+	}
+
+	return nil
+}
+
 func listProjectVideos(c *gin.Context) {
 	p, err := stg.GetProject(c.Param("name"))
 	if err != nil {
@@ -750,6 +1851,7 @@ func listProjectVideos(c *gin.Context) {
 		"total":     len(list),
 		"video":     subVideos,
 		"totalSize": humanize.Bytes(uint64(totalSize)),
+		"repaired":  repairs[p.Name],
 	}))
 }
 
@@ -774,8 +1876,199 @@ func drainLatest(c *gin.Context, first []byte, frames <-chan []byte) ([]byte, bo
 	}
 }
 
+// previewPacer 限制预览帧率；自适应模式下根据写出耗时调整发送间隔
+type previewPacer struct {
+	// minInterval 由请求的最大帧率决定
+	minInterval time.Duration
+	interval    time.Duration
+	adaptive    bool
+	last        time.Time
+}
+
+func newPreviewPacer(fps float64, adaptive bool) *previewPacer {
+	p := &previewPacer{adaptive: adaptive}
+	if fps > 0 {
+		p.minInterval = time.Duration(float64(time.Second) / fps)
+	}
+	p.interval = p.minInterval
+
+	return p
+}
+
+func (p *previewPacer) ready(now time.Time) bool {
+	return now.Sub(p.last) >= p.interval
+}
+
+// sent 记录一帧的发送：写出超过间隔的一半时放慢，远小于间隔时逐步恢复
+func (p *previewPacer) sent(start time.Time, cost time.Duration) {
+	p.last = start
+	if !p.adaptive {
+		return
+	}
+	if cost > p.interval/2 {
+		p.interval = min(max(p.interval*3/2, cost*2), maxPreviewInterval)
+	} else if cost*4 < p.interval {
+		p.interval = max(p.interval*9/10, p.minInterval)
+	}
+}
+
+// renderPreview 按观看者的设置缩小或重新编码预览帧
+func renderPreview(frame []byte, width, height, quality int) ([]byte, error) {
+	fw, fh, err := video.FrameSize(frame)
+	if err != nil {
+		return nil, err
+	}
+	resize := fw > width || fh > height
+	if !resize && quality <= 0 {
+		return frame, nil
+	}
+	img, err := imaging.Decode(frame)
+	if err != nil {
+		return nil, err
+	}
+	if resize {
+		// 保持比例缩小到 width x height 以内
+		w, h := imaging.FitSize(fw, fh, 0, height)
+		if fw*height > fh*width {
+			w, h = imaging.FitSize(fw, fh, width, 0)
+		}
+		img = imaging.Resize(img, w, h)
+	}
+
+	return imaging.Encode(img, quality)
+}
+
+// captureOnce 在调度锁内用临时的控制参数拍摄一张照片，不影响正在运行的项目；
+// used 为拍摄时设备上 controls 各参数的实际取值
+func captureOnce(u *camera.Unit, setting types.CaptureSetting, controls types.CameraSettings) (frame []byte, used []ov.Config, err error) {
+	err = schedulers.Get(u).Exclusive(func() error {
+		restore := u.Camera.Override(controls)
+		defer restore()
+
+		frame, err = u.Controller.Capture(u.CaptureSetting(setting))
+		if err != nil || len(controls) == 0 {
+			return err
+		}
+		used, err = u.Camera.ReadControls(controls)
+		if err != nil {
+			logger.Warnf("read controls after capture err: %s", err)
+		}
+		return nil
+	})
+
+	return frame, used, err
+}
+
+// snapshot 拍摄单张照片，直接返回 JPEG 或保存到 snapshots 目录
+func snapshot(c *gin.Context) {
+	var req ov.Snapshot
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+			return
+		}
+	}
+	u, ok := getReadyUnit(c)
+	if !ok {
+		return
+	}
+	frame, _, err := captureOnce(u, req.Capture, req.Controls)
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	if !req.Save {
+		c.Data(http.StatusOK, "image/jpeg", frame)
+		return
+	}
+	name, err := stg.SaveSnapshot(strings.ReplaceAll(u.Info().ID, "/", "_"), frame)
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsend.Success(map[string]any{
+		"name": name,
+	}))
+}
+
+func getSnapshot(c *gin.Context) {
+	p, err := stg.GetSnapshotPath(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+		return
+	}
+	if _, err = os.Stat(p); err != nil {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr("snapshot not found"))
+		return
+	}
+
+	c.File(p)
+}
+
+// previewSize 补全并对齐预览分辨率，未指定时使用拍照分辨率的 1/4
+func previewSize(u *camera.Unit, width, height int) (int, int) {
+	w, h := u.Size()
+	if width <= 0 && height <= 0 {
+		width, height = w/4, h/4
+	} else if w > 0 && h > 0 {
+		width, height = imaging.FitSize(w, h, width, height)
+	}
+
+	return u.PreviewSize(width, height)
+}
+
+// webrtcPreview 以 WebRTC 推送实时预览：请求体为浏览器的 offer，返回 answer。
+// 只使用本机地址作为 ICE 候选，不依赖 STUN/TURN，适用于局域网
+func webrtcPreview(c *gin.Context) {
+	var q ov.WebRTCPreview
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+		return
+	}
+	var offer webrtc.SessionDescription
+	if err := c.ShouldBindJSON(&offer); err != nil {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+		return
+	}
+	u, ok := getUnit(c, q.Camera)
+	if !ok || !cameraReady(c, u) {
+		return
+	}
+	w, h := previewSize(u, q.Width, q.Height)
+	viewer, err := u.Preview.Subscribe(w, h)
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	answer, err := rtcServer.Answer(offer, viewer, rtc.Options{
+		Codec:   q.Codec,
+		Encoder: q.Encoder,
+		Width:   w,
+		FPS:     int(q.FPS),
+		Bitrate: q.Bitrate,
+	})
+	if err != nil {
+		viewer.Close()
+		internalErr(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsend.Success(answer))
+}
+
 func realtimeVideo(c *gin.Context) {
-	frames, err := controller.StartPreview(consts.Width/4, consts.Height/4)
+	var q ov.Preview
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, jsend.SimpleErr(err.Error()))
+		return
+	}
+	u, ok := getUnit(c, q.Camera)
+	if !ok || !cameraReady(c, u) {
+		return
+	}
+	q.Width, q.Height = previewSize(u, q.Width, q.Height)
+	viewer, err := u.Preview.Subscribe(q.Width, q.Height)
 	if err != nil {
 		logger.Error(err)
 		internalErr(c, err)
@@ -783,16 +2076,15 @@ func realtimeVideo(c *gin.Context) {
 	}
 	defer func() {
 		logger.Info("stop realtime video")
-		err := controller.StopPreview()
-		if err != nil {
-			logger.Error(err)
-		}
+		viewer.Close()
 	}()
+	frames := viewer.Frames()
 
 	mimeWriter := multipart.NewWriter(c.Writer)
 	c.Header("Content-Type", fmt.Sprintf("multipart/x-mixed-replace; boundary=%s", mimeWriter.Boundary()))
 	partHeader := make(textproto.MIMEHeader)
 	partHeader.Add("Content-Type", "image/jpeg")
+	pacer := newPreviewPacer(q.FPS, q.Adaptive)
 
 	for {
 		select {
@@ -805,11 +2097,21 @@ func realtimeVideo(c *gin.Context) {
 				logger.Error("empty frame received")
 				continue
 			}
-			err := writeMimePart(c, mimeWriter, partHeader, frame)
+			start := time.Now()
+			if !pacer.ready(start) {
+				continue
+			}
+			frame, err = renderPreview(frame, q.Width, q.Height, q.Quality)
+			if err != nil {
+				logger.Errorf("failed to render preview: %s", err)
+				continue
+			}
+			err = writeMimePart(c, mimeWriter, partHeader, frame)
 			if err != nil {
 				logger.Errorf("failed to write image: %s", err)
 				return
 			}
+			pacer.sent(start, time.Since(start))
 			logger.Debugf("write frame len %d", len(frame))
 		case <-time.After(5 * time.Second):
 			logger.Errorf("timeout reading frame")
@@ -824,6 +2126,7 @@ func realtimeVideo(c *gin.Context) {
 				return
 			}
 		case <-c.Done():
+			return
 		}
 	}
 }
@@ -874,6 +2177,49 @@ func registerStaticsDir(group gin.IRoutes, dir, relativeGroup string) error {
 	})
 }
 
+// mimeTypes 系统 MIME 表中可能缺少的类型
+var mimeTypes = map[string]string{
+	".avi":  "video/x-msvideo",
+	".mp4":  "video/mp4",
+	".jpg":  "image/jpeg",
+	".webp": "image/webp",
+}
+
+// serveFile 发送文件，支持 Range、HEAD 以及 ETag/Last-Modified 缓存校验；attachment 为 true 时作为附件下载
+func serveFile(c *gin.Context, file string, attachment bool) {
+	f, err := os.Open(file)
+	if err != nil {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr(fmt.Sprintf("%s not found", path.Base(file))))
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		internalErr(c, err)
+		return
+	}
+	if info.IsDir() {
+		c.JSON(http.StatusNotFound, jsend.SimpleErr(fmt.Sprintf("%s not found", path.Base(file))))
+		return
+	}
+	name := path.Base(file)
+	ext := strings.ToLower(path.Ext(name))
+	ctype, ok := mimeTypes[ext]
+	if !ok {
+		ctype = mime.TypeByExtension(ext)
+	}
+	if ctype != "" {
+		c.Header("Content-Type", ctype)
+	}
+	disposition := "inline"
+	if attachment {
+		disposition = "attachment"
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": name}))
+	c.Header("ETag", fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()))
+	http.ServeContent(c.Writer, c.Request, name, info.ModTime(), f)
+}
+
 func internalErr(c *gin.Context, err error) {
 	logger.Debug(err)
 	c.JSON(http.StatusInternalServerError, jsend.SimpleErr(err.Error()))
@@ -0,0 +1,504 @@
+package archive
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 不压缩的 zip（照片已是 JPEG，压缩几乎没有收益）。文件结构在创建时即可确定，
+// 因此总大小可预先得知，并能从任意位置读取，支持 HTTP Range 断点续传。
+const (
+	localHeaderLen   = 30
+	descriptorLen    = 16
+	centralHeaderLen = 46
+	endLen           = 22
+
+	// zip64 时额外的长度
+	localExtra64   = 20
+	descriptor64   = 24
+	centralExtra64 = 28
+	end64Len       = 56 + 20
+
+	flagDescriptor = 0x08
+	flagUTF8       = 0x800
+
+	// maxCRCCache 为缓存的 CRC32 数量上限
+	maxCRCCache = 10000
+)
+
+// crcCache 按路径、大小与修改时间缓存文件的 CRC32，续传时不必重新读取前面的文件
+var crcCache = newCRCCache(maxCRCCache)
+
+// crcLRU 超过 size 个时淘汰最久未使用的 CRC32
+type crcLRU struct {
+	size int
+
+	lock  sync.Mutex
+	list  *list.List
+	items map[string]*list.Element
+}
+
+type crcItem struct {
+	key string
+	crc uint32
+}
+
+func newCRCCache(size int) *crcLRU {
+	return &crcLRU{size: size, list: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *crcLRU) Load(key string) (uint32, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return 0, false
+	}
+	c.list.MoveToFront(el)
+
+	return el.Value.(*crcItem).crc, true
+}
+
+func (c *crcLRU) Store(key string, crc uint32) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		el.Value.(*crcItem).crc = crc
+		c.list.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.list.PushFront(&crcItem{key: key, crc: crc})
+	for c.list.Len() > c.size {
+		el := c.list.Back()
+		c.list.Remove(el)
+		delete(c.items, el.Value.(*crcItem).key)
+	}
+}
+
+func (c *crcLRU) Clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.list.Init()
+	clear(c.items)
+}
+
+// Entry 为压缩包中的一个文件
+type Entry struct {
+	Name     string
+	Path     string
+	Size     int64
+	Modified time.Time
+}
+
+type entry struct {
+	Entry
+	// offset 为本地文件头的位置
+	offset int64
+
+	crc    uint32
+	known  bool
+	h      hash.Hash32
+	hashed int64
+}
+
+type segKind int
+
+const (
+	segLocal segKind = iota
+	segData
+	segDescriptor
+	segCentral
+	segEnd
+)
+
+type segment struct {
+	off, size int64
+	kind      segKind
+	entry     int
+}
+
+// Zip 为按需生成的 zip，实现 io.ReaderAt
+type Zip struct {
+	entries  []*entry
+	segments []segment
+	zip64    bool
+	size     int64
+	cdOffset int64
+	cdSize   int64
+
+	// cur 为最近读取的文件
+	cur    *os.File
+	curIdx int
+}
+
+// New 按 entries 的顺序创建 zip，文件在读取时才打开
+func New(entries []Entry) *Zip {
+	z := newZip(entries, false)
+	if z.size > math.MaxUint32 || len(entries) >= math.MaxUint16 {
+		z = newZip(entries, true)
+	}
+
+	return z
+}
+
+func newZip(entries []Entry, zip64 bool) *Zip {
+	z := &Zip{zip64: zip64, curIdx: -1}
+	var off int64
+	add := func(size int64, kind segKind, i int) {
+		z.segments = append(z.segments, segment{off: off, size: size, kind: kind, entry: i})
+		off += size
+	}
+	for i, e := range entries {
+		z.entries = append(z.entries, &entry{Entry: e, offset: off})
+		add(z.localLen(e), segLocal, i)
+		add(e.Size, segData, i)
+		add(z.descriptorLen(), segDescriptor, i)
+	}
+	z.cdOffset = off
+	for i, e := range entries {
+		add(z.centralLen(e), segCentral, i)
+	}
+	z.cdSize = off - z.cdOffset
+	endSize := int64(endLen)
+	if zip64 {
+		endSize += end64Len
+	}
+	add(endSize, segEnd, 0)
+	z.size = off
+
+	return z
+}
+
+// Size 返回 zip 的总大小
+func (z *Zip) Size() int64 {
+	return z.size
+}
+
+// Close 关闭正在读取的文件
+func (z *Zip) Close() error {
+	if z.cur == nil {
+		return nil
+	}
+	err := z.cur.Close()
+	z.cur, z.curIdx = nil, -1
+
+	return err
+}
+
+func (z *Zip) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	i := sort.Search(len(z.segments), func(i int) bool {
+		s := z.segments[i]
+		return s.off+s.size > off
+	})
+	n := 0
+	for ; i < len(z.segments) && n < len(p); i++ {
+		s := z.segments[i]
+		if s.size == 0 {
+			continue
+		}
+		start := off + int64(n) - s.off
+		want := min(int64(len(p)-n), s.size-start)
+		var err error
+		if s.kind == segData {
+			err = z.readData(s.entry, p[n:n+int(want)], start)
+		} else {
+			var b []byte
+			if b, err = z.header(s); err == nil {
+				copy(p[n:], b[start:start+want])
+			}
+		}
+		if err != nil {
+			return n, err
+		}
+		n += int(want)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// readData 读取文件内容，顺序读取时顺便计算 CRC32
+func (z *Zip) readData(i int, p []byte, pos int64) error {
+	e := z.entries[i]
+	if z.curIdx != i {
+		if err := z.Close(); err != nil {
+			return err
+		}
+		f, err := os.Open(e.Path)
+		if err != nil {
+			return err
+		}
+		z.cur, z.curIdx = f, i
+	}
+	if _, err := z.cur.ReadAt(p, pos); err != nil {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s changed during download", e.Name)
+		}
+		return err
+	}
+	if !e.known && pos == e.hashed {
+		if e.h == nil {
+			e.h = crc32.NewIEEE()
+		}
+		e.h.Write(p)
+		e.hashed += int64(len(p))
+		if e.hashed == e.Size {
+			e.setCRC(e.h.Sum32())
+		}
+	}
+
+	return nil
+}
+
+func (e *entry) key() string {
+	return fmt.Sprintf("%s|%d|%d", e.Path, e.Size, e.Modified.UnixNano())
+}
+
+func (e *entry) setCRC(crc uint32) {
+	e.crc, e.known, e.h = crc, true, nil
+	crcCache.Store(e.key(), crc)
+}
+
+// checksum 返回文件的 CRC32，没有顺序读取过时读取整个文件计算
+func (e *entry) checksum() (uint32, error) {
+	if e.known {
+		return e.crc, nil
+	}
+	if crc, ok := crcCache.Load(e.key()); ok {
+		e.crc, e.known = crc, true
+		return e.crc, nil
+	}
+	f, err := os.Open(e.Path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	h := crc32.NewIEEE()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, err
+	}
+	if n != e.Size {
+		return 0, fmt.Errorf("%s changed during download", e.Name)
+	}
+	e.setCRC(h.Sum32())
+
+	return e.crc, nil
+}
+
+func (z *Zip) localLen(e Entry) int64 {
+	n := int64(localHeaderLen + len(e.Name))
+	if z.zip64 {
+		n += localExtra64
+	}
+
+	return n
+}
+
+func (z *Zip) descriptorLen() int64 {
+	if z.zip64 {
+		return descriptor64
+	}
+
+	return descriptorLen
+}
+
+func (z *Zip) centralLen(e Entry) int64 {
+	n := int64(centralHeaderLen + len(e.Name))
+	if z.zip64 {
+		n += centralExtra64
+	}
+
+	return n
+}
+
+func (z *Zip) version() uint16 {
+	if z.zip64 {
+		return 45
+	}
+
+	return 20
+}
+
+// header 生成 s 对应的文件头、数据描述符或目录
+func (z *Zip) header(s segment) ([]byte, error) {
+	w := &writer{}
+	switch s.kind {
+	case segLocal:
+		e := z.entries[s.entry]
+		date, tm := dosTime(e.Modified)
+		w.u32(0x04034b50)
+		w.u16(z.version())
+		w.u16(flagDescriptor | flagUTF8)
+		w.u16(0) // store
+		w.u16(tm)
+		w.u16(date)
+		w.u32(0) // CRC 在数据描述符中
+		if z.zip64 {
+			w.u32(math.MaxUint32)
+			w.u32(math.MaxUint32)
+		} else {
+			w.u32(uint32(e.Size))
+			w.u32(uint32(e.Size))
+		}
+		w.u16(uint16(len(e.Name)))
+		if z.zip64 {
+			w.u16(localExtra64)
+		} else {
+			w.u16(0)
+		}
+		w.str(e.Name)
+		if z.zip64 {
+			w.u16(1)
+			w.u16(16)
+			w.u64(uint64(e.Size))
+			w.u64(uint64(e.Size))
+		}
+	case segDescriptor:
+		e := z.entries[s.entry]
+		crc, err := e.checksum()
+		if err != nil {
+			return nil, err
+		}
+		w.u32(0x08074b50)
+		w.u32(crc)
+		if z.zip64 {
+			w.u64(uint64(e.Size))
+			w.u64(uint64(e.Size))
+		} else {
+			w.u32(uint32(e.Size))
+			w.u32(uint32(e.Size))
+		}
+	case segCentral:
+		e := z.entries[s.entry]
+		crc, err := e.checksum()
+		if err != nil {
+			return nil, err
+		}
+		date, tm := dosTime(e.Modified)
+		w.u32(0x02014b50)
+		w.u16(z.version())
+		w.u16(z.version())
+		w.u16(flagDescriptor | flagUTF8)
+		w.u16(0)
+		w.u16(tm)
+		w.u16(date)
+		w.u32(crc)
+		if z.zip64 {
+			w.u32(math.MaxUint32)
+			w.u32(math.MaxUint32)
+		} else {
+			w.u32(uint32(e.Size))
+			w.u32(uint32(e.Size))
+		}
+		w.u16(uint16(len(e.Name)))
+		if z.zip64 {
+			w.u16(centralExtra64)
+		} else {
+			w.u16(0)
+		}
+		w.u16(0) // comment
+		w.u16(0) // disk
+		w.u16(0) // internal attributes
+		w.u32(0) // external attributes
+		if z.zip64 {
+			w.u32(math.MaxUint32)
+		} else {
+			w.u32(uint32(e.offset))
+		}
+		w.str(e.Name)
+		if z.zip64 {
+			w.u16(1)
+			w.u16(24)
+			w.u64(uint64(e.Size))
+			w.u64(uint64(e.Size))
+			w.u64(uint64(e.offset))
+		}
+	case segEnd:
+		count := uint64(len(z.entries))
+		if z.zip64 {
+			end64 := z.cdOffset + z.cdSize
+			w.u32(0x06064b50)
+			w.u64(44)
+			w.u16(45)
+			w.u16(45)
+			w.u32(0)
+			w.u32(0)
+			w.u64(count)
+			w.u64(count)
+			w.u64(uint64(z.cdSize))
+			w.u64(uint64(z.cdOffset))
+			// locator
+			w.u32(0x07064b50)
+			w.u32(0)
+			w.u64(uint64(end64))
+			w.u32(1)
+		}
+		w.u32(0x06054b50)
+		w.u16(0)
+		w.u16(0)
+		if z.zip64 {
+			w.u16(math.MaxUint16)
+			w.u16(math.MaxUint16)
+			w.u32(math.MaxUint32)
+			w.u32(math.MaxUint32)
+		} else {
+			w.u16(uint16(count))
+			w.u16(uint16(count))
+			w.u32(uint32(z.cdSize))
+			w.u32(uint32(z.cdOffset))
+		}
+		w.u16(0)
+	}
+
+	return w.buf, nil
+}
+
+// dosTime 返回 MS-DOS 格式的日期与时间
+func dosTime(t time.Time) (uint16, uint16) {
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, t.Location())
+	}
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	tm := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+
+	return date, tm
+}
+
+type writer struct {
+	buf []byte
+}
+
+func (w *writer) u16(v uint16) {
+	w.buf = binary.LittleEndian.AppendUint16(w.buf, v)
+}
+
+func (w *writer) u32(v uint32) {
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, v)
+}
+
+func (w *writer) u64(v uint64) {
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, v)
+}
+
+func (w *writer) str(s string) {
+	w.buf = append(w.buf, s...)
+}
@@ -0,0 +1,100 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func testEntries(t *testing.T) ([]Entry, map[string][]byte) {
+	dir := t.TempDir()
+	var entries []Entry
+	files := make(map[string][]byte)
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("p-%07d.jpg", i)
+		data := bytes.Repeat([]byte{byte(i)}, 1000*i+7)
+		p := path.Join(dir, name)
+		if err := os.WriteFile(p, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, Entry{Name: name, Path: p, Size: int64(len(data)), Modified: time.Date(2024, 5, 1, 12, 30, 10, 0, time.UTC)})
+		files[name] = data
+	}
+
+	return entries, files
+}
+
+func TestZip(t *testing.T) {
+	entries, files := testEntries(t)
+	for _, zip64 := range []bool{false, true} {
+		z := newZip(entries, zip64)
+		data, err := io.ReadAll(io.NewSectionReader(z, 0, z.Size()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = z.Close()
+		if int64(len(data)) != z.Size() {
+			t.Fatalf("got %d bytes, want %d", len(data), z.Size())
+		}
+		r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("zip64 %v: %s", zip64, err)
+		}
+		if len(r.File) != len(entries) {
+			t.Fatalf("got %d files", len(r.File))
+		}
+		for _, f := range r.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(rc)
+			_ = rc.Close()
+			if err != nil {
+				t.Fatalf("%s: %s", f.Name, err)
+			}
+			if !bytes.Equal(got, files[f.Name]) {
+				t.Fatalf("%s differs", f.Name)
+			}
+			if got := f.Modified.Format(time.DateTime); got != "2024-05-01 12:30:10" {
+				t.Fatalf("got modified %v", f.Modified)
+			}
+		}
+
+		// 续传：从中间位置开始读取的内容与完整读取一致
+		for _, off := range []int64{1, 37, z.Size() / 2, z.Size() - 30} {
+			crcCache.Clear()
+			z2 := newZip(entries, zip64)
+			part, err := io.ReadAll(io.NewSectionReader(z2, off, z2.Size()-off))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = z2.Close()
+			if !bytes.Equal(part, data[off:]) {
+				t.Fatalf("zip64 %v: read from %d differs", zip64, off)
+			}
+		}
+	}
+}
+
+func TestCRCCache(t *testing.T) {
+	c := newCRCCache(2)
+	c.Store("a", 1)
+	c.Store("b", 2)
+	c.Load("a")
+	c.Store("c", 3)
+	if _, ok := c.Load("b"); ok {
+		t.Fatal("least recently used entry should be evicted")
+	}
+	if crc, ok := c.Load("a"); !ok || crc != 1 {
+		t.Fatalf("got %d %v", crc, ok)
+	}
+	if len(c.items) != 2 || c.list.Len() != 2 {
+		t.Fatalf("got %d entries", len(c.items))
+	}
+}
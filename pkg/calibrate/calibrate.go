@@ -0,0 +1,93 @@
+package calibrate
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+
+	"plant-shutter-pi/pkg/types"
+)
+
+const (
+	// 增益范围，避免灰卡被遮挡或过暗时把画面拉坏
+	minGain = 0.25
+	maxGain = 4
+)
+
+// Gains 为 R、G、B 三个通道的增益，即对角颜色矩阵
+type Gains [3]float64
+
+// Measure 计算 patch 区域的平均 RGB(0-255)
+func Measure(img image.Image, patch types.Rect) ([3]float64, error) {
+	b := img.Bounds()
+	r := image.Rect(patch.Left, patch.Top, patch.Left+patch.Width, patch.Top+patch.Height).
+		Add(b.Min).
+		Intersect(b)
+	if r.Empty() {
+		return [3]float64{}, fmt.Errorf("calibration patch %+v is outside of the image %v", patch, b.Size())
+	}
+	var sum [3]uint64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			cr, cg, cb, _ := img.At(x, y).RGBA()
+			sum[0] += uint64(cr >> 8)
+			sum[1] += uint64(cg >> 8)
+			sum[2] += uint64(cb >> 8)
+		}
+	}
+	n := float64(r.Dx() * r.Dy())
+
+	return [3]float64{float64(sum[0]) / n, float64(sum[1]) / n, float64(sum[2]) / n}, nil
+}
+
+// Compute 计算把灰卡平均色 mean 校正为中性灰的增益，target 为 0 时保持灰卡的平均亮度
+func Compute(mean [3]float64, target int) Gains {
+	grey := float64(target)
+	if grey <= 0 {
+		grey = (mean[0] + mean[1] + mean[2]) / 3
+	}
+	var g Gains
+	for i, m := range mean {
+		g[i] = clamp(grey / math.Max(m, 1))
+	}
+
+	return g
+}
+
+// Smooth 以系数 k 将 cur 与上一张的增益 prev 混合，prev 为 nil 时返回 cur
+func Smooth(prev *Gains, cur Gains, k float64) Gains {
+	if prev == nil || k <= 0 {
+		return cur
+	}
+	var g Gains
+	for i := range g {
+		g[i] = prev[i]*k + cur[i]*(1-k)
+	}
+
+	return g
+}
+
+// Apply 按增益校正 img
+func Apply(img image.Image, g Gains) *image.RGBA {
+	var lut [3][256]uint8
+	for c := range lut {
+		for v := range lut[c] {
+			lut[c][v] = uint8(min(255, math.Round(float64(v)*g[c])))
+		}
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		dst.Pix[i] = lut[0][dst.Pix[i]]
+		dst.Pix[i+1] = lut[1][dst.Pix[i+1]]
+		dst.Pix[i+2] = lut[2][dst.Pix[i+2]]
+	}
+
+	return dst
+}
+
+func clamp(g float64) float64 {
+	return math.Min(maxGain, math.Max(minGain, g))
+}
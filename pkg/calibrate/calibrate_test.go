@@ -0,0 +1,40 @@
+package calibrate
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"plant-shutter-pi/pkg/types"
+)
+
+func TestCalibrate(t *testing.T) {
+	// 偏暖的画面：灰卡测得 (150, 120, 90)
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{R: 150, G: 120, B: 90, A: 255}), image.Point{}, draw.Src)
+
+	mean, err := Measure(img, types.Rect{Left: 10, Top: 10, Width: 10, Height: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mean != [3]float64{150, 120, 90} {
+		t.Fatalf("got mean %v", mean)
+	}
+	out := Apply(img, Compute(mean, 0))
+	if c := out.RGBAAt(15, 15); c.R != 120 || c.G != 120 || c.B != 120 {
+		t.Fatalf("got %v, want neutral grey 120", c)
+	}
+	out = Apply(img, Compute(mean, 180))
+	if c := out.RGBAAt(0, 0); c.R != 180 || c.G != 180 || c.B != 180 {
+		t.Fatalf("got %v, want neutral grey 180", c)
+	}
+
+	prev := Gains{1, 1, 1}
+	if g := Smooth(&prev, Gains{2, 2, 2}, 0.75); g != (Gains{1.25, 1.25, 1.25}) {
+		t.Fatalf("got %v", g)
+	}
+	if _, err = Measure(img, types.Rect{Left: 50, Top: 50, Width: 10, Height: 10}); err == nil {
+		t.Fatal("expected error for patch outside of the image")
+	}
+}
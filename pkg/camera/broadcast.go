@@ -0,0 +1,121 @@
+package camera
+
+import (
+	"sync"
+)
+
+// Broadcaster 将一路预览分发给多个观看者。
+// 第一个观看者加入时启动预览，最后一个离开时停止；
+// 每个观看者只缓存最新一帧，处理慢的观看者会丢弃旧帧而不影响其他人。
+type Broadcaster struct {
+	controller *Controller
+
+	lock    sync.Mutex
+	viewers map[*Viewer]struct{}
+	// src 为当前预览通道，为 nil 表示预览未启动
+	src <-chan []byte
+}
+
+// Viewer 为一个预览观看者
+type Viewer struct {
+	b  *Broadcaster
+	ch chan []byte
+}
+
+func NewBroadcaster(controller *Controller) *Broadcaster {
+	return &Broadcaster{
+		controller: controller,
+		viewers:    make(map[*Viewer]struct{}),
+	}
+}
+
+// Subscribe 加入预览，预览未启动时以 width x height 启动；已启动时沿用当前分辨率
+func (b *Broadcaster) Subscribe(width, height int) (*Viewer, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.src == nil {
+		src, err := b.controller.StartPreview(width, height)
+		if err != nil {
+			return nil, err
+		}
+		b.src = src
+		go b.fanout(src)
+	}
+	v := &Viewer{b: b, ch: make(chan []byte, 1)}
+	b.viewers[v] = struct{}{}
+	logger.Infof("preview viewer joined, %d watching", len(b.viewers))
+
+	return v, nil
+}
+
+// Viewers 返回当前观看者数量
+func (b *Broadcaster) Viewers() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return len(b.viewers)
+}
+
+func (b *Broadcaster) fanout(src <-chan []byte) {
+	for frame := range src {
+		b.lock.Lock()
+		for v := range b.viewers {
+			v.push(frame)
+		}
+		b.lock.Unlock()
+	}
+
+	// 预览通道被关闭，通知仍在观看的人
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.src != src {
+		// 已被新的预览取代
+		return
+	}
+	for v := range b.viewers {
+		close(v.ch)
+		delete(b.viewers, v)
+	}
+	b.src = nil
+}
+
+// Frames 返回观看者的帧通道，预览异常结束时被关闭
+func (v *Viewer) Frames() <-chan []byte {
+	return v.ch
+}
+
+// Close 离开预览，最后一个观看者离开时停止预览
+func (v *Viewer) Close() {
+	b := v.b
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.viewers[v]; !ok {
+		return
+	}
+	delete(b.viewers, v)
+	logger.Infof("preview viewer left, %d watching", len(b.viewers))
+	if len(b.viewers) > 0 || b.src == nil {
+		return
+	}
+	b.src = nil
+	if err := b.controller.StopPreview(); err != nil {
+		logger.Warnf("stop preview err: %s", err)
+	}
+}
+
+// push 非阻塞写入，缓冲已满时用新帧替换旧帧
+func (v *Viewer) push(frame []byte) {
+	for {
+		select {
+		case v.ch <- frame:
+			return
+		default:
+		}
+		select {
+		case <-v.ch:
+		default:
+		}
+	}
+}
@@ -5,17 +5,25 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"plant-shutter-pi/pkg/types"
 )
 
+// streamFrameTimeout 为常开流等待下一帧的最长时间
+const streamFrameTimeout = 5 * time.Second
+
 // Controller 使用持久的预览通道来管理预览与拍照。
 //
 // 行为：
 //   - StartPreview(width,height) 以给定分辨率启动设备，
 //     并返回 JPEG 帧的通道。该通道在预览生命周期内保持不变；
 //     StopPreview 会将其关闭。
-//   - Capture(width,height) 拍摄单张照片。若预览正在运行，
+//   - Capture(setting) 按拍照设置（分辨率、格式、裁剪）拍摄单张照片。若预览正在运行，
 //     将临时停止设备，切换到拍照分辨率获取一帧，随后恢复至预览分辨率。
 //     拍照期间预览通道保持打开，但不会收到帧。
+//   - StartStream(setting) 以拍照设置保持设备常开，
+//     之后的 Capture 直接取最新一帧，避免每次拍照重新打开设备、分配缓冲区。
+//     常开期间预览复用该流。
 type Controller struct {
 	mu sync.Mutex
 
@@ -33,6 +41,17 @@ type Controller struct {
 
 	// 状态标志
 	previewing bool
+
+	// 常开拍照流
+	streaming bool
+	sMode     types.CaptureSetting
+	// streamFwd 将常开流的帧转发给预览循环
+	streamFwd chan []byte
+	// latest 为常开流最近收到的一帧；frameNotify 在每次收到新帧时关闭并重建
+	latest      []byte
+	frameNotify chan struct{}
+	// streamDone 在常开流结束（设备停止或出错）时关闭
+	streamDone chan struct{}
 }
 
 // NewController 创建一个绑定到设备路径的控制器。
@@ -54,14 +73,22 @@ func (c *Controller) StartPreview(width, height int) (<-chan []byte, error) {
 		c.previewCh = make(chan []byte, 1)
 		c.loopStop = make(chan struct{})
 		c.srcUpdate = make(chan (<-chan []byte), 1)
-		go c.previewLoop()
+		go c.previewLoop(c.previewCh, c.loopStop, c.srcUpdate)
+	}
+
+	c.pW, c.pH = width, height
+	if c.streaming {
+		// 常开流期间直接复用其帧
+		c.previewing = true
+		c.srcUpdate <- c.streamFwd
+
+		return c.previewCh, nil
 	}
 
-	frames, err := c.cam.Start(width, height)
+	frames, err := c.cam.Start(types.CaptureSetting{Width: width, Height: height})
 	if err != nil {
 		return nil, err
 	}
-	c.pW, c.pH = width, height
 	c.previewing = true
 
 	c.srcUpdate <- frames
@@ -78,8 +105,10 @@ func (c *Controller) StopPreview() error {
 		return nil
 	}
 	c.previewing = false
-	// 先停止设备，使循环停止接收帧
-	_ = c.cam.Stop()
+	// 先停止设备，使循环停止接收帧；常开流期间设备由拍照流占用，不停止
+	if !c.streaming {
+		_ = c.cam.Stop()
+	}
 	// 通知循环退出并关闭预览通道
 	if c.loopStop != nil {
 		close(c.loopStop)
@@ -92,11 +121,29 @@ func (c *Controller) StopPreview() error {
 	return nil
 }
 
-// Capture 以 width x height 捕获一帧并返回 []byte。
+// Capture 按拍照设置捕获一帧 JPEG 并返回 []byte。
 // 若预览正在运行，拍照期间预览通道保持打开但暂停发送，之后自动恢复。
-func (c *Controller) Capture(width, height int) ([]byte, error) {
-	// 在锁内决定状态切换
+func (c *Controller) Capture(setting types.CaptureSetting) ([]byte, error) {
 	c.mu.Lock()
+	if c.streaming {
+		if c.sMode == setting {
+			c.mu.Unlock()
+			return c.captureFromStream()
+		}
+		// 设置不同：临时关闭常开流，拍照后再恢复
+		sMode := c.sMode
+		c.mu.Unlock()
+		if err := c.StopStream(); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := c.StartStream(sMode); err != nil {
+				logger.Warnf("failed to restart stream after capture: %v", err)
+			}
+		}()
+		c.mu.Lock()
+	}
+	// 在锁内决定状态切换
 	wasPreviewing := c.previewing
 	// 切换到拍照状态
 	c.previewing = false
@@ -107,8 +154,8 @@ func (c *Controller) Capture(width, height int) ([]byte, error) {
 		_ = c.cam.Stop()
 	}
 
-	// 以请求的分辨率启动拍照流
-	frames, err := c.cam.Start(width, height)
+	// 以请求的设置启动拍照流
+	frames, err := c.cam.Start(setting)
 	if err != nil {
 		// 失败时尝试恢复预览状态
 		if wasPreviewing {
@@ -168,38 +215,188 @@ func (c *Controller) Capture(width, height int) ([]byte, error) {
 	return img, nil
 }
 
-// previewLoop 将当前源的帧复用转发到 previewCh。
-// 它会在临时停止（如拍照）期间保持 previewCh 打开，
-// 仅当调用 StopPreview 时才关闭。
-func (c *Controller) previewLoop() {
+// StartStream 按拍照设置保持设备常开，供短间隔拍照使用。
+// 若预览正在运行，预览将切换为复用该流。
+func (c *Controller) StartStream(setting types.CaptureSetting) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.streaming {
+		if c.sMode == setting {
+			return nil
+		}
+		return errors.New("stream already started")
+	}
+	if c.previewing {
+		// 停止预览流；预览循环将暂停（无源）
+		_ = c.cam.Stop()
+	}
+
+	frames, err := c.cam.Start(setting)
+	if err != nil {
+		if c.previewing {
+			c.resumePreviewLocked()
+		}
+		return err
+	}
+	c.streaming = true
+	c.sMode = setting
+	c.streamFwd = make(chan []byte, 1)
+	c.frameNotify = make(chan struct{})
+	c.latest = nil
+	c.streamDone = make(chan struct{})
+	go c.streamLoop(frames, c.streamFwd, c.streamDone)
+
+	if c.previewing {
+		c.srcUpdate <- c.streamFwd
+	}
+
+	return nil
+}
+
+// StopStream 关闭常开流；若预览正在运行，则恢复至预览分辨率。
+func (c *Controller) StopStream() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.streaming {
+		return nil
+	}
+	c.streaming = false
+	// 设备关闭后 streamLoop 会退出并关闭 streamFwd
+	err := c.cam.Stop()
+	c.latest = nil
+
+	if c.previewing {
+		c.resumePreviewLocked()
+	}
+
+	return err
+}
+
+// captureFromStream 等待常开流的下一帧并返回，保证帧是在调用之后拍摄的。
+func (c *Controller) captureFromStream() ([]byte, error) {
+	c.mu.Lock()
+	notify, done := c.frameNotify, c.streamDone
+	c.mu.Unlock()
+
+	select {
+	case <-notify:
+	case <-done:
+		// 流结束前可能恰好收到了新帧
+		select {
+		case <-notify:
+		default:
+			return nil, errors.New("capture stream closed")
+		}
+	case <-time.After(streamFrameTimeout):
+		return nil, errors.New("timeout waiting for stream frame")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.latest == nil {
+		return nil, errors.New("capture stream closed")
+	}
+
+	return append([]byte(nil), c.latest...), nil
+}
+
+// streamLoop 持续读取常开流，保存最新帧并转发给预览；流结束时关闭 fwd 与 done。
+func (c *Controller) streamLoop(frames <-chan []byte, fwd chan []byte, done chan struct{}) {
+	defer close(done)
+	defer close(fwd)
+	for frame := range frames {
+		if len(frame) == 0 {
+			continue
+		}
+		c.mu.Lock()
+		c.latest = frame
+		close(c.frameNotify)
+		c.frameNotify = make(chan struct{})
+		c.mu.Unlock()
+
+		// 非阻塞转发；若预览未运行或处理慢则丢弃
+		select {
+		case fwd <- frame:
+		default:
+		}
+	}
+}
+
+// Disconnect 在设备掉线后释放设备句柄，保留常开流与预览状态以便 Recover 恢复。
+func (c *Controller) Disconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_ = c.cam.Stop()
+}
+
+// Recover 在设备重新连接后重新打开设备，恢复常开流或预览。
+func (c *Controller) Recover() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// 释放掉线前残留的句柄
+	_ = c.cam.Stop()
+	if c.streaming {
+		frames, err := c.cam.Start(c.sMode)
+		if err != nil {
+			return err
+		}
+		c.streamFwd = make(chan []byte, 1)
+		c.streamDone = make(chan struct{})
+		go c.streamLoop(frames, c.streamFwd, c.streamDone)
+		if c.previewing {
+			c.srcUpdate <- c.streamFwd
+		}
+
+		return nil
+	}
+	if c.previewing {
+		fr, err := c.resumePreview(c.pW, c.pH)
+		if err != nil {
+			return err
+		}
+		c.srcUpdate <- fr
+	}
+
+	return nil
+}
+
+// resumePreviewLocked 在常开流变化后恢复独立的预览流，调用方需持有 c.mu。
+func (c *Controller) resumePreviewLocked() {
+	fr, err := c.resumePreview(c.pW, c.pH)
+	if err != nil {
+		logger.Warnf("failed to resume preview: %v", err)
+		return
+	}
+	c.srcUpdate <- fr
+}
+
+// previewLoop 将当前源的帧复用转发到 out。
+// 它会在临时停止（如拍照）期间保持 out 打开，
+// 仅当 stop 被关闭（StopPreview）时才关闭 out。
+// 通道由参数传入，StopPreview 后重新开始的预览使用新的通道，互不影响。
+func (c *Controller) previewLoop(out chan []byte, stop <-chan struct{}, update <-chan (<-chan []byte)) {
+	defer close(out)
 	var current <-chan []byte
 	for {
 		// 若当前无源，等待更新或停止信号
 		if current == nil {
 			select {
-			case <-c.loopStop:
-				// 关闭对外通道并退出
-				c.mu.Lock()
-				if c.previewCh != nil {
-					close(c.previewCh)
-				}
-				c.mu.Unlock()
+			case <-stop:
 				return
-			case ch := <-c.srcUpdate:
+			case ch := <-update:
 				current = ch
 			}
 			continue
 		}
 
 		select {
-		case <-c.loopStop:
-			c.mu.Lock()
-			if c.previewCh != nil {
-				close(c.previewCh)
-			}
-			c.mu.Unlock()
+		case <-stop:
 			return
-		case ch := <-c.srcUpdate:
+		case ch := <-update:
 			// 切换到新源
 			current = ch
 		case frame, ok := <-current:
@@ -213,7 +410,7 @@ func (c *Controller) previewLoop() {
 			}
 			// 非阻塞转发；若消费者处理慢则丢弃
 			select {
-			case c.previewCh <- append([]byte(nil), frame...):
+			case out <- append([]byte(nil), frame...):
 			default:
 				// 为避免阻塞而丢帧
 			}
@@ -230,7 +427,7 @@ func (c *Controller) resumePreview(width, height int) (<-chan []byte, error) {
 		err error
 	)
 	for i := 0; i < 5; i++ {
-		fr, err = c.cam.Start(width, height)
+		fr, err = c.cam.Start(types.CaptureSetting{Width: width, Height: height})
 		if err == nil {
 			return fr, nil
 		}
@@ -0,0 +1,143 @@
+package camera
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+
+	"github.com/vladimirvivien/go4vl/v4l2"
+)
+
+const defaultQuality = 90
+
+// standardDHT 为 JPEG 标准(K.3) Huffman 表段，USB 摄像头输出的 MJPEG 帧通常省略该段
+var standardDHT []byte
+
+func init() {
+	var buf bytes.Buffer
+	_ = jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil)
+	// Go 的编码器使用的正是标准表，直接取出其 DHT 段
+	data := buf.Bytes()
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			break
+		}
+		l := int(data[i+2])<<8 | int(data[i+3])
+		if data[i+1] == 0xc4 {
+			standardDHT = append([]byte(nil), data[i:i+2+l]...)
+			break
+		}
+		i += 2 + l
+	}
+}
+
+// frameConverter 将设备输出的原始帧转换为 JPEG
+type frameConverter func(frame []byte) ([]byte, error)
+
+// newConverter 按设备实际输出的像素格式选择转换器，JPEG 源不做处理
+func newConverter(pix v4l2.PixFormat, quality int) (frameConverter, error) {
+	switch pix.PixelFormat {
+	case v4l2.PixelFmtJPEG:
+		if quality <= 0 {
+			return nil, nil
+		}
+		return func(frame []byte) ([]byte, error) {
+			return reencodeJPEG(frame, quality)
+		}, nil
+	case v4l2.PixelFmtMJPEG:
+		return func(frame []byte) ([]byte, error) {
+			frame = insertDHT(frame)
+			if quality <= 0 {
+				return frame, nil
+			}
+			return reencodeJPEG(frame, quality)
+		}, nil
+	case v4l2.PixelFmtYUYV:
+		if quality <= 0 {
+			quality = defaultQuality
+		}
+		w, h, stride := int(pix.Width), int(pix.Height), int(pix.BytesPerLine)
+		if stride == 0 {
+			stride = w * 2
+		}
+		return func(frame []byte) ([]byte, error) {
+			return yuyvToJPEG(frame, w, h, stride, quality)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported pixel format %s", fourCC(pix.PixelFormat))
+	}
+}
+
+// insertDHT 在缺少 Huffman 表的 MJPEG 帧中插入标准表，使其成为完整的 JPEG
+func insertDHT(frame []byte) []byte {
+	if len(frame) < 4 || frame[0] != 0xff || frame[1] != 0xd8 {
+		return frame
+	}
+	for i := 2; i+4 <= len(frame); {
+		if frame[i] != 0xff {
+			return frame
+		}
+		marker := frame[i+1]
+		switch marker {
+		case 0xc4:
+			return frame
+		case 0xda:
+			// 在 SOS 之前插入
+			res := make([]byte, 0, len(frame)+len(standardDHT))
+			res = append(res, frame[:i]...)
+			res = append(res, standardDHT...)
+			return append(res, frame[i:]...)
+		}
+		i += 2 + (int(frame[i+2])<<8 | int(frame[i+3]))
+	}
+
+	return frame
+}
+
+func reencodeJPEG(frame []byte, quality int) ([]byte, error) {
+	img, err := jpeg.Decode(bytes.NewReader(frame))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func yuyvToJPEG(frame []byte, w, h, stride, quality int) ([]byte, error) {
+	if len(frame) < stride*(h-1)+w*2 {
+		return nil, fmt.Errorf("yuyv frame too short: %d bytes for %d*%d", len(frame), w, h)
+	}
+	img := image.NewYCbCr(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio422)
+	for y := 0; y < h; y++ {
+		row := frame[y*stride:]
+		yRow := img.Y[y*img.YStride:]
+		cRow := y * img.CStride
+		for x := 0; x+1 < w; x += 2 {
+			i := x * 2
+			yRow[x] = row[i]
+			yRow[x+1] = row[i+2]
+			img.Cb[cRow+x/2] = row[i+1]
+			img.Cr[cRow+x/2] = row[i+3]
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// pixelFormat 将 FourCC 字符串转换为 v4l2 像素格式，为空时使用 JPEG
+func pixelFormat(format string) v4l2.FourCCType {
+	if len(format) != 4 {
+		return v4l2.PixelFmtJPEG
+	}
+
+	return v4l2.FourCCType(format[0]) | v4l2.FourCCType(format[1])<<8 | v4l2.FourCCType(format[2])<<16 | v4l2.FourCCType(format[3])<<24
+}
@@ -0,0 +1,49 @@
+package camera
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func TestInsertDHT(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 16)), nil); err != nil {
+		t.Fatal(err)
+	}
+	full := buf.Bytes()
+	// 去掉 DHT 段，模拟 MJPEG 帧
+	i := bytes.Index(full, standardDHT)
+	if i < 0 {
+		t.Fatal("standard DHT not found")
+	}
+	stripped := append(append([]byte(nil), full[:i]...), full[i+len(standardDHT):]...)
+
+	fixed := insertDHT(stripped)
+	if _, err := jpeg.Decode(bytes.NewReader(fixed)); err != nil {
+		t.Fatalf("decode fixed frame: %s", err)
+	}
+	if got := insertDHT(full); !bytes.Equal(got, full) {
+		t.Fatal("frame with DHT should be unchanged")
+	}
+}
+
+func TestYUYVToJPEG(t *testing.T) {
+	w, h := 4, 2
+	frame := bytes.Repeat([]byte{200, 128, 200, 128}, w*h/2)
+	data, err := yuyvToJPEG(frame, w, h, w*2, 90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != w || cfg.Height != h {
+		t.Fatalf("got %d*%d, want %d*%d", cfg.Width, cfg.Height, w, h)
+	}
+	if _, err = yuyvToJPEG(frame[:3], w, h, w*2, 90); err == nil {
+		t.Fatal("expected error for short frame")
+	}
+}
@@ -5,8 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"maps"
+	"slices"
 	"sync"
-	"time"
+	"syscall"
 
 	"github.com/vladimirvivien/go4vl/device"
 	"github.com/vladimirvivien/go4vl/v4l2"
@@ -25,6 +26,8 @@ type Camera struct {
 	lock   sync.Mutex
 	cancel context.CancelFunc
 	camera *device.Device
+	// convert 将设备输出转换为 JPEG，设备直接输出 JPEG 时为 nil
+	convert frameConverter
 
 	settings types.CameraSettings
 }
@@ -33,7 +36,7 @@ func New(ctx context.Context, devName string) *Camera {
 	return &Camera{ctx: ctx, devName: devName, settings: make(types.CameraSettings)}
 }
 
-func (c *Camera) open(width, height int) error {
+func (c *Camera) open(s types.CaptureSetting) error {
 	if c.camera != nil {
 		return StartedErr
 	}
@@ -41,30 +44,68 @@ func (c *Camera) open(width, height int) error {
 		c.devName,
 		device.WithBufferSize(1),
 		device.WithPixFormat(v4l2.PixFormat{
-			PixelFormat: v4l2.PixelFmtJPEG,
-			Width:       uint32(width),
-			Height:      uint32(height),
+			PixelFormat: pixelFormat(s.Format),
+			Width:       uint32(s.Width),
+			Height:      uint32(s.Height),
 		}),
 	)
 	if err != nil {
 		return err
 	}
+	if !s.Crop.Empty() {
+		err = camera.SetCropRect(v4l2.Rect{
+			Left:   int32(s.Crop.Left),
+			Top:    int32(s.Crop.Top),
+			Width:  uint32(s.Crop.Width),
+			Height: uint32(s.Crop.Height),
+		})
+		if err != nil {
+			_ = camera.Close()
+			return err
+		}
+	}
+	// 驱动可能调整为其支持的格式，按实际格式选择转换方式
+	pix, err := v4l2.GetPixFormat(camera.Fd())
+	if err != nil {
+		_ = camera.Close()
+		return err
+	}
+	convert, err := newConverter(pix, s.Quality)
+	if err != nil {
+		_ = camera.Close()
+		return err
+	}
 	c.camera = camera
+	c.convert = convert
 
 	return nil
 }
 
+func (c *Camera) DevName() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.devName
+}
+
+// SetDevName 更新设备节点路径，下次打开设备时生效
+func (c *Camera) SetDevName(devName string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.devName = devName
+}
+
 func (c *Camera) IsStarted() bool {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	return c.camera != nil
 }
 
-func (c *Camera) Start(width, height int) (<-chan []byte, error) {
+// Start 按拍照设置打开设备并开始输出 JPEG 帧
+func (c *Camera) Start(s types.CaptureSetting) (<-chan []byte, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	logger.Infof("start camera in %d*%d", width, height)
-	err := c.open(width, height)
+	logger.Infof("start camera in %d*%d %s", s.Width, s.Height, s.Format)
+	err := c.open(s)
 	if err != nil {
 		return nil, err
 	}
@@ -77,19 +118,40 @@ func (c *Camera) Start(width, height int) (<-chan []byte, error) {
 
 	c.applySettings()
 
-	return c.camera.GetOutput(), nil
+	if c.convert == nil {
+		return c.camera.GetOutput(), nil
+	}
+
+	return convertFrames(c.camera.GetOutput(), c.convert), nil
+}
+
+func convertFrames(in <-chan []byte, convert frameConverter) <-chan []byte {
+	out := make(chan []byte, 1)
+	go func() {
+		defer close(out)
+		for frame := range in {
+			if len(frame) > 0 {
+				var err error
+				if frame, err = convert(frame); err != nil {
+					logger.Warnf("convert frame err: %s", err)
+					continue
+				}
+			}
+			out <- frame
+		}
+	}()
+
+	return out
 }
 
 func (c *Camera) Stop() error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	if c.cancel != nil {
-		// 先取消上下文，让底层流处理 goroutine 走到 ctx.Done 分支并调用 d.Stop()
 		c.cancel()
-		// 短暂等待，避免我们随即调用 Close() 时与底层 goroutine 的 Stop() 并发执行
-		time.Sleep(100 * time.Millisecond)
 		c.cancel = nil
 	}
+	// Close 会等待底层流处理 goroutine 退出后再释放缓冲区
 	if c.camera != nil {
 		err := c.camera.Close()
 		c.camera = nil
@@ -98,6 +160,17 @@ func (c *Camera) Stop() error {
 	return nil
 }
 
+// Err 返回设备流异常结束（如设备被拔出）的原因，设备未打开或流正常运行时返回 nil
+func (c *Camera) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.camera == nil {
+		return nil
+	}
+
+	return c.camera.Err()
+}
+
 func (c *Camera) ResetSettings() {
 	c.UpdateSettings(initSettings)
 }
@@ -129,6 +202,72 @@ func (c *Camera) SetControlValue(key v4l2.CtrlID, value v4l2.CtrlValue) error {
 	return c.applySetting(key, value)
 }
 
+// Override 临时覆盖部分控制参数，返回的 restore 恢复覆盖前的设置与设备状态
+func (c *Camera) Override(overrides types.CameraSettings) (restore func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	prev := maps.Clone(c.settings)
+	// 不在当前设置中的参数需记录覆盖前设备上的取值，其余参数由 prev 恢复
+	before := make(types.CameraSettings)
+	err := c.withFd(func(fd uintptr) error {
+		for k := range overrides {
+			if _, ok := prev[k]; ok {
+				continue
+			}
+			v, err := v4l2.GetControlValue(fd, k)
+			if err != nil {
+				logger.Warnf("get ctrl(%d) err: %s", k, err)
+				continue
+			}
+			before[k] = v
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Warnf("read ctrls before override err: %s", err)
+	}
+	settings := maps.Clone(prev)
+	maps.Copy(settings, overrides)
+	c.settings = settings
+	c.applySettings()
+
+	return func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		c.settings = prev
+		if len(before) > 0 {
+			err := c.withFd(func(fd uintptr) error {
+				for k, v := range before {
+					if err := v4l2.SetControlValue(fd, k, v); err != nil {
+						logger.Warnf("restore ctrl(%d) to %d, err: %s", k, v, err)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				logger.Warnf("restore ctrls err: %s", err)
+			}
+		}
+		c.applySettings()
+	}
+}
+
+// withFd 设备已打开时使用其句柄，否则临时打开设备节点（不设置格式），调用方需持有 c.lock
+func (c *Camera) withFd(fn func(fd uintptr) error) error {
+	if c.camera != nil {
+		return fn(c.camera.Fd())
+	}
+	fd, err := v4l2.OpenDevice(c.devName, syscall.O_RDWR|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	defer v4l2.CloseDevice(fd)
+
+	return fn(fd)
+}
+
 func (c *Camera) applySetting(k v4l2.CtrlID, v v4l2.CtrlValue) error {
 	if c.camera == nil {
 		return nil
@@ -180,7 +319,37 @@ func (c *Camera) GetKnownCtrlSettings() (types.CameraSettings, error) {
 	return res, nil
 }
 
-func (c *Camera) GetMaxSize() (width, height int, err error) {
+// ReadControls 读取设备上 settings 中各参数的当前值与说明，按 ID 排序
+func (c *Camera) ReadControls(settings types.CameraSettings) ([]ov.Config, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ids := slices.Sorted(maps.Keys(settings))
+	res := make([]ov.Config, 0, len(ids))
+	err := c.withFd(func(fd uintptr) error {
+		for _, id := range ids {
+			ctrl, err := v4l2.GetControl(fd, id)
+			if err != nil {
+				logger.Debugf("The device does not support control(%d)", id)
+				continue
+			}
+			cfg, err := ctrlToConfig(ctrl)
+			if err != nil {
+				return err
+			}
+			res = append(res, cfg)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GetMaxSize 返回指定格式的最大分辨率，设备不支持该格式时返回所有格式中的最大值
+func (c *Camera) GetMaxSize(format string) (width, height int, err error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
@@ -205,15 +374,23 @@ func (c *Camera) GetMaxSize() (width, height int, err error) {
 	if err != nil {
 		return
 	}
+	pf := pixelFormat(format)
 	for _, size := range sizes {
-		if size.PixelFormat == v4l2.PixelFmtJPEG {
+		if size.PixelFormat == pf {
 			width = int(size.Size.MaxWidth)
 			height = int(size.Size.MaxHeight)
 
 			return
 		}
 	}
-	err = fmt.Errorf("unable to determine the maximum pixels of the camera")
+	for _, size := range sizes {
+		if w, h := int(size.Size.MaxWidth), int(size.Size.MaxHeight); w*h > width*height {
+			width, height = w, h
+		}
+	}
+	if width == 0 || height == 0 {
+		err = fmt.Errorf("unable to determine the maximum pixels of the camera")
+	}
 
 	return
 }
@@ -0,0 +1,63 @@
+package camera
+
+import (
+	"strconv"
+	"time"
+
+	"plant-shutter-pi/pkg/exif"
+	"plant-shutter-pi/pkg/types"
+)
+
+const (
+	ctrlExposureAuto     = 10094849 // V4L2_CID_EXPOSURE_AUTO，1 为手动
+	ctrlExposureAbsolute = 10094850 // 单位 100us
+	ctrlWhiteBalanceAuto = 10094868 // V4L2_CID_AUTO_N_PRESET_WHITE_BALANCE，1 为自动
+	ctrlISO              = 10094871
+	ctrlISOAuto          = 10094872 // 1 为自动
+)
+
+// Metadata 读取设备当前生效的曝光参数，供写入照片的 EXIF；不支持的参数保持为未知
+func (u *Unit) Metadata() exif.Metadata {
+	m := exif.Metadata{Device: u.Info().Card}
+	configs, err := u.Camera.ReadControls(types.CameraSettings{
+		ctrlExposureAuto:     0,
+		ctrlExposureAbsolute: 0,
+		ctrlWhiteBalanceAuto: 0,
+		ctrlISO:              0,
+		ctrlISOAuto:          0,
+	})
+	if err != nil {
+		logger.Warnf("read exposure controls err: %s", err)
+		return m
+	}
+	values := make(map[uint32]int32)
+	menus := make(map[uint32]map[uint32]string)
+	for _, cfg := range configs {
+		values[cfg.ID] = cfg.Value
+		menus[cfg.ID] = cfg.MenuItems
+	}
+
+	if v, ok := values[ctrlExposureAuto]; ok {
+		m.ExposureMode = exif.ModeAuto
+		if v == 1 {
+			m.ExposureMode = exif.ModeManual
+			m.ExposureTime = time.Duration(values[ctrlExposureAbsolute]) * 100 * time.Microsecond
+		}
+	}
+	if v, ok := values[ctrlWhiteBalanceAuto]; ok {
+		m.WhiteBalance = exif.ModeManual
+		if v == 1 {
+			m.WhiteBalance = exif.ModeAuto
+		}
+	}
+	if v, ok := values[ctrlISO]; ok && values[ctrlISOAuto] != 1 {
+		// ISO 通常为整数菜单，菜单项名称即 ISO 值
+		if name, ok := menus[ctrlISO][uint32(v)]; ok {
+			m.ISO, _ = strconv.Atoi(name)
+		} else if menus[ctrlISO] == nil {
+			m.ISO = int(v)
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,435 @@
+package camera
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/vladimirvivien/go4vl/device"
+	"github.com/vladimirvivien/go4vl/v4l2"
+
+	"plant-shutter-pi/pkg/types"
+)
+
+const scanInterval = 5 * time.Second
+
+type FrameSize struct {
+	Width     int  `json:"width"`
+	Height    int  `json:"height"`
+	MinWidth  int  `json:"minWidth,omitempty"`
+	MinHeight int  `json:"minHeight,omitempty"`
+	Stepwise  bool `json:"stepwise,omitempty"`
+}
+
+type Format struct {
+	PixelFormat string      `json:"pixelFormat"`
+	Description string      `json:"description"`
+	Sizes       []FrameSize `json:"sizes"`
+}
+
+// Info 为枚举到的采集设备信息
+type Info struct {
+	// ID 稳定标识：优先使用序列号，否则使用总线信息；设备节点路径在重新插拔后可能变化
+	ID      string   `json:"id"`
+	Path    string   `json:"path"`
+	Card    string   `json:"card"`
+	Driver  string   `json:"driver"`
+	BusInfo string   `json:"busInfo"`
+	Serial  string   `json:"serial,omitempty"`
+	Formats []Format `json:"formats"`
+	// CropBounds 传感器可裁剪范围，设备不支持裁剪时为 nil
+	CropBounds *types.Rect `json:"cropBounds,omitempty"`
+}
+
+// Unit 为一个摄像头及其独立的控制器与监视器
+type Unit struct {
+	Camera     *Camera
+	Controller *Controller
+	Supervisor *Supervisor
+	// Preview 多个观看者共享的实时预览
+	Preview *Broadcaster
+
+	lock          sync.Mutex
+	info          Info
+	width, height int
+}
+
+func (u *Unit) Info() Info {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	return u.info
+}
+
+// Size 返回设备连接时探测到的拍照分辨率
+func (u *Unit) Size() (width, height int) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	return u.width, u.height
+}
+
+// CaptureSetting 补全拍照设置，未指定分辨率时使用 Size
+func (u *Unit) CaptureSetting(s types.CaptureSetting) types.CaptureSetting {
+	if s.Width <= 0 || s.Height <= 0 {
+		s.Width, s.Height = u.Size()
+	}
+
+	return s
+}
+
+// PreviewSize 将预览分辨率对齐到设备支持的尺寸：
+// 取不小于请求的最小尺寸，都小于请求时取最大尺寸；设备未上报尺寸时原样返回。
+// 预览以 JPEG 格式打开设备，只考虑 MJPG/JPEG 格式的尺寸，设备不支持时才考虑其他格式。
+func (u *Unit) PreviewSize(width, height int) (int, int) {
+	info := u.Info()
+	formats := make([]Format, 0, len(info.Formats))
+	for _, f := range info.Formats {
+		if f.PixelFormat == fourCC(v4l2.PixelFmtMJPEG) || f.PixelFormat == fourCC(v4l2.PixelFmtJPEG) {
+			formats = append(formats, f)
+		}
+	}
+	if len(formats) == 0 {
+		formats = info.Formats
+	}
+	var best, largest FrameSize
+	for _, f := range formats {
+		for _, s := range f.Sizes {
+			if s.Stepwise {
+				s.Width = min(max(width, s.MinWidth), s.Width)
+				s.Height = min(max(height, s.MinHeight), s.Height)
+			}
+			if s.Width*s.Height > largest.Width*largest.Height {
+				largest = s
+			}
+			if s.Width >= width && s.Height >= height &&
+				(best.Width == 0 || s.Width*s.Height < best.Width*best.Height) {
+				best = s
+			}
+		}
+	}
+	if best.Width > 0 {
+		return best.Width, best.Height
+	}
+	if largest.Width > 0 {
+		return largest.Width, largest.Height
+	}
+
+	return width, height
+}
+
+// Registry 枚举 /dev/video* 采集节点，并按稳定 ID 管理多个摄像头。
+type Registry struct {
+	ctx context.Context
+
+	lock      sync.Mutex
+	units     map[string]*Unit
+	defaultID string
+	// 默认设备的固定分辨率，<=0 表示使用最大分辨率
+	defaultW, defaultH int
+	onConnect          func(u *Unit) error
+}
+
+// NewRegistry 创建注册表并开始周期性扫描设备。
+// defaultDev 为默认摄像头的设备路径，即使启动时不存在也会等待其接入；
+// onConnect 在任一摄像头（重新）连接后调用。
+func NewRegistry(ctx context.Context, defaultDev string, w, h int, onConnect func(u *Unit) error) *Registry {
+	r := &Registry{
+		ctx:       ctx,
+		units:     make(map[string]*Unit),
+		defaultW:  w,
+		defaultH:  h,
+		onConnect: onConnect,
+	}
+	info, err := probe(defaultDev)
+	if err != nil {
+		// 暂用路径作为 ID，设备接入后由扫描更新为稳定 ID
+		info = Info{ID: defaultDev, Path: defaultDev}
+	}
+	r.defaultID = info.ID
+	r.addLocked(info)
+	r.scan()
+	go r.run()
+
+	return r
+}
+
+// Default 返回默认摄像头
+func (r *Registry) Default() *Unit {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.units[r.defaultID]
+}
+
+// DefaultID 返回默认摄像头的 ID
+func (r *Registry) DefaultID() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.defaultID
+}
+
+// Get 按 ID 查找摄像头，id 为空时返回默认摄像头
+func (r *Registry) Get(id string) *Unit {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if id == "" {
+		id = r.defaultID
+	}
+
+	return r.units[id]
+}
+
+// List 返回所有已知摄像头，按 ID 排序
+func (r *Registry) List() []*Unit {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	ids := make([]string, 0, len(r.units))
+	for id := range r.units {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	res := make([]*Unit, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, r.units[id])
+	}
+
+	return res
+}
+
+func (r *Registry) run() {
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-time.After(scanInterval):
+			r.scan()
+		}
+	}
+}
+
+// scan 枚举采集节点：新设备创建 Unit，已知设备更新路径（重新插拔后 videoN 可能变化）
+func (r *Registry) scan() {
+	paths, err := device.GetAllDevicePaths()
+	if err != nil {
+		logger.Warnf("list video devices err: %s", err)
+		return
+	}
+	seen := make(map[string]int)
+	for _, p := range paths {
+		if !strings.HasPrefix(path.Base(p), "video") {
+			continue
+		}
+		info, err := probe(p)
+		if err != nil {
+			continue
+		}
+		// 同一总线上有多个采集节点时追加序号区分
+		if n := seen[info.ID]; n > 0 {
+			seen[info.ID]++
+			info.ID = fmt.Sprintf("%s#%d", info.ID, n)
+		} else {
+			seen[info.ID] = 1
+		}
+
+		r.lock.Lock()
+		if u, ok := r.units[info.ID]; ok {
+			u.update(info)
+		} else if u, ok := r.units[info.Path]; ok && u.info.ID == info.Path {
+			// 启动时未就绪的默认设备，换成稳定 ID
+			delete(r.units, info.Path)
+			r.units[info.ID] = u
+			if r.defaultID == info.Path {
+				r.defaultID = info.ID
+			}
+			u.lock.Lock()
+			u.info.ID = info.ID
+			u.lock.Unlock()
+			u.update(info)
+		} else {
+			logger.Infof("found camera %s (%s) at %s", info.ID, info.Card, info.Path)
+			r.addLocked(info)
+		}
+		r.lock.Unlock()
+	}
+}
+
+func (r *Registry) addLocked(info Info) {
+	cam := New(r.ctx, info.Path)
+	cam.ResetSettings()
+	u := &Unit{
+		Camera:     cam,
+		Controller: NewController(cam),
+		info:       info,
+	}
+	u.Preview = NewBroadcaster(u.Controller)
+	isDefault := info.ID == r.defaultID
+	u.Supervisor = NewSupervisor(r.ctx, u.Controller, func() error {
+		w, h := 0, 0
+		if isDefault {
+			w, h = r.defaultW, r.defaultH
+		}
+		if w <= 0 || h <= 0 {
+			var err error
+			w, h, err = cam.GetMaxSize("")
+			if err != nil {
+				return err
+			}
+		}
+		u.lock.Lock()
+		u.width, u.height = w, h
+		u.lock.Unlock()
+		if info, err := probe(cam.DevName()); err == nil {
+			u.update(info)
+		}
+		if r.onConnect != nil {
+			return r.onConnect(u)
+		}
+
+		return nil
+	})
+	r.units[info.ID] = u
+}
+
+func (u *Unit) update(info Info) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if u.info.Path != info.Path {
+		logger.Infof("camera %s moved from %s to %s", u.info.ID, u.info.Path, info.Path)
+		u.Camera.SetDevName(info.Path)
+	}
+	// 保留已确定的 ID
+	info.ID = u.info.ID
+	u.info = info
+}
+
+// probe 查询设备能力与支持的格式，非视频采集节点（元数据、编解码 M2M 等）返回错误
+func probe(devPath string) (Info, error) {
+	fd, err := v4l2.OpenDevice(devPath, syscall.O_RDWR|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return Info{}, err
+	}
+	defer v4l2.CloseDevice(fd)
+
+	caps, err := v4l2.GetCapability(fd)
+	if err != nil {
+		return Info{}, err
+	}
+	c := caps.GetCapabilities()
+	if c&v4l2.CapVideoCapture == 0 || c&v4l2.CapStreaming == 0 || c&(v4l2.CapVideoMem2Mem|v4l2.CapVideoMem2MemMPlane) != 0 {
+		return Info{}, fmt.Errorf("%s is not a video capture device", devPath)
+	}
+
+	info := Info{
+		Path:    devPath,
+		Card:    caps.Card,
+		Driver:  caps.Driver,
+		BusInfo: caps.BusInfo,
+		Serial:  readSerial(devPath),
+		Formats: listFormats(fd),
+	}
+	if cc, err := v4l2.GetCropCapability(fd, v4l2.BufTypeVideoCapture); err == nil {
+		info.CropBounds = &types.Rect{
+			Left:   int(cc.Bounds.Left),
+			Top:    int(cc.Bounds.Top),
+			Width:  int(cc.Bounds.Width),
+			Height: int(cc.Bounds.Height),
+		}
+	}
+	info.ID = info.Serial
+	if info.ID == "" {
+		info.ID = info.BusInfo
+	}
+
+	return info, nil
+}
+
+// FindEncoder 查找硬件视频编码器（如树莓派的 bcm2835-codec-encode），没有时返回空
+func FindEncoder() string {
+	paths, err := device.GetAllDevicePaths()
+	if err != nil {
+		return ""
+	}
+	for _, p := range paths {
+		fd, err := v4l2.OpenDevice(p, syscall.O_RDWR|syscall.O_NONBLOCK, 0)
+		if err != nil {
+			continue
+		}
+		caps, err := v4l2.GetCapability(fd)
+		v4l2.CloseDevice(fd)
+		if err != nil {
+			continue
+		}
+		if caps.GetCapabilities()&(v4l2.CapVideoMem2Mem|v4l2.CapVideoMem2MemMPlane) != 0 &&
+			strings.Contains(strings.ToLower(caps.Card), "enc") {
+			return p
+		}
+	}
+
+	return ""
+}
+
+func listFormats(fd uintptr) []Format {
+	descs, err := v4l2.GetAllFormatDescriptions(fd)
+	if err != nil {
+		return nil
+	}
+	sizes, _ := v4l2.GetAllFormatFrameSizes(fd)
+
+	res := make([]Format, 0, len(descs))
+	for _, d := range descs {
+		f := Format{
+			PixelFormat: fourCC(d.PixelFormat),
+			Description: d.Description,
+		}
+		for _, s := range sizes {
+			if s.PixelFormat != d.PixelFormat {
+				continue
+			}
+			f.Sizes = append(f.Sizes, FrameSize{
+				Width:     int(s.Size.MaxWidth),
+				Height:    int(s.Size.MaxHeight),
+				MinWidth:  int(s.Size.MinWidth),
+				MinHeight: int(s.Size.MinHeight),
+				Stepwise:  s.Type != v4l2.FrameSizeTypeDiscrete,
+			})
+		}
+		res = append(res, f)
+	}
+
+	return res
+}
+
+// readSerial 读取 USB 设备序列号，非 USB 设备返回空
+func readSerial(devPath string) string {
+	sysDir, err := filepath.EvalSymlinks(path.Join("/sys/class/video4linux", path.Base(devPath), "device"))
+	if err != nil {
+		return ""
+	}
+	// device 指向 USB 接口，序列号在其上一级的 USB 设备目录
+	for _, dir := range []string{sysDir, path.Dir(sysDir)} {
+		data, err := os.ReadFile(path.Join(dir, "serial"))
+		if err == nil {
+			if s := strings.TrimSpace(string(data)); s != "" {
+				return s
+			}
+		}
+	}
+
+	return ""
+}
+
+func fourCC(f v4l2.FourCCType) string {
+	return string([]byte{byte(f), byte(f >> 8), byte(f >> 16), byte(f >> 24)})
+}
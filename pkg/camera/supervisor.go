@@ -0,0 +1,147 @@
+package camera
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	StateConnected    State = "connected"
+	StateReconnecting State = "reconnecting"
+	StateFailed       State = "failed"
+
+	// 连续失败超过该次数后状态记为 failed，但仍会按最大间隔继续重试
+	maxReconnectRetries = 5
+
+	watchInterval = time.Second
+	minBackoff    = time.Second
+	maxBackoff    = 30 * time.Second
+)
+
+type Status struct {
+	State     State     `json:"state"`
+	Device    string    `json:"device"`
+	LastError string    `json:"lastError,omitempty"`
+	Retries   int       `json:"retries"`
+	Since     time.Time `json:"since"`
+}
+
+// Supervisor 监视设备节点的出现与消失，设备掉线后按退避策略重新打开，
+// 并在重新连接后恢复控制器的常开流或预览。
+type Supervisor struct {
+	cam        *Camera
+	controller *Controller
+	// onConnect 在每次（重新）连接成功时调用，用于探测分辨率等
+	onConnect func() error
+
+	lock   sync.Mutex
+	status Status
+}
+
+// NewSupervisor 创建并启动设备监视，ctx 结束时退出。
+func NewSupervisor(ctx context.Context, controller *Controller, onConnect func() error) *Supervisor {
+	s := &Supervisor{
+		cam:        controller.cam,
+		controller: controller,
+		onConnect:  onConnect,
+		status: Status{
+			State: StateReconnecting,
+			Since: time.Now(),
+		},
+	}
+	go s.run(ctx)
+
+	return s
+}
+
+// Status 返回当前设备状态。
+func (s *Supervisor) Status() Status {
+	s.lock.Lock()
+	st := s.status
+	s.lock.Unlock()
+	st.Device = s.cam.DevName()
+
+	return st
+}
+
+// Connected 返回设备当前是否可用。
+func (s *Supervisor) Connected() bool {
+	return s.Status().State == StateConnected
+}
+
+func (s *Supervisor) run(ctx context.Context) {
+	backoff := time.Duration(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if s.Connected() {
+			backoff = watchInterval
+			// 设备节点消失，或流因设备错误结束（节点仍在但已无法读取）
+			_, err := os.Stat(s.cam.DevName())
+			if err == nil {
+				err = s.cam.Err()
+			}
+			if err != nil {
+				logger.Warnf("camera %s disconnected: %s", s.cam.DevName(), err)
+				s.controller.Disconnect()
+				s.setStatus(StateReconnecting, err)
+				backoff = minBackoff
+			}
+			continue
+		}
+
+		if err := s.connect(); err != nil {
+			st := s.Status()
+			state := StateReconnecting
+			if st.Retries+1 >= maxReconnectRetries {
+				state = StateFailed
+			}
+			s.setStatus(state, err)
+			backoff = min(max(backoff*2, minBackoff), maxBackoff)
+			logger.Warnf("camera %s is not ready, retry in %s: %s", s.cam.DevName(), backoff, err)
+			continue
+		}
+		logger.Infof("camera %s connected", s.cam.DevName())
+		s.setStatus(StateConnected, nil)
+		backoff = watchInterval
+	}
+}
+
+func (s *Supervisor) connect() error {
+	if _, err := os.Stat(s.cam.DevName()); err != nil {
+		return err
+	}
+	if s.onConnect != nil {
+		if err := s.onConnect(); err != nil {
+			return err
+		}
+	}
+
+	return s.controller.Recover()
+}
+
+func (s *Supervisor) setStatus(state State, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if state != s.status.State {
+		s.status.Since = time.Now()
+	}
+	switch state {
+	case StateConnected:
+		s.status.Retries = 0
+	default:
+		s.status.Retries++
+	}
+	s.status.State = state
+	if err != nil {
+		s.status.LastError = err.Error()
+	}
+}
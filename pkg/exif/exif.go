@@ -0,0 +1,455 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"plant-shutter-pi/pkg/types"
+)
+
+const (
+	ModeUnknown = iota
+	ModeAuto
+	ModeManual
+)
+
+const (
+	typeByte     = 1
+	typeASCII    = 2
+	typeShort    = 3
+	typeLong     = 4
+	typeRational = 5
+
+	tagImageDescription = 0x010e
+	tagModel            = 0x0110
+	tagSoftware         = 0x0131
+	tagDateTime         = 0x0132
+	tagHostComputer     = 0x013c
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+
+	tagExposureTime     = 0x829a
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagImageNumber      = 0x9211
+	tagExposureMode     = 0xa402
+	tagWhiteBalance     = 0xa403
+
+	tagGPSVersionID    = 0x0000
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+	tagGPSAltitudeRef  = 0x0005
+	tagGPSAltitude     = 0x0006
+
+	dateLayout = "2006:01:02 15:04:05"
+	software   = "plant-shutter"
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+
+	ErrNotFound = errors.New("exif not found")
+)
+
+var tagNames = map[uint16]string{
+	tagImageDescription: "ImageDescription",
+	tagModel:            "Model",
+	tagSoftware:         "Software",
+	tagDateTime:         "DateTime",
+	tagHostComputer:     "HostComputer",
+	tagExposureTime:     "ExposureTime",
+	tagISO:              "ISOSpeedRatings",
+	tagDateTimeOriginal: "DateTimeOriginal",
+	tagImageNumber:      "ImageNumber",
+	tagExposureMode:     "ExposureMode",
+	tagWhiteBalance:     "WhiteBalance",
+}
+
+var gpsTagNames = map[uint16]string{
+	tagGPSVersionID:    "GPSVersionID",
+	tagGPSLatitudeRef:  "GPSLatitudeRef",
+	tagGPSLatitude:     "GPSLatitude",
+	tagGPSLongitudeRef: "GPSLongitudeRef",
+	tagGPSLongitude:    "GPSLongitude",
+	tagGPSAltitudeRef:  "GPSAltitudeRef",
+	tagGPSAltitude:     "GPSAltitude",
+}
+
+// Metadata 为写入照片的拍摄信息，零值字段不写入
+type Metadata struct {
+	Time     time.Time
+	Project  string
+	Sequence int
+	Host     string
+	// Device 摄像头名称，写入 Model
+	Device string
+
+	ExposureTime time.Duration
+	// ExposureMode 与 WhiteBalance 取 ModeUnknown/ModeAuto/ModeManual
+	ExposureMode int
+	WhiteBalance int
+	ISO          int
+
+	Location *types.Location
+}
+
+// Write 将 m 写入 JPEG 的 APP1 段，已有的 EXIF 段被替换
+func Write(jpeg []byte, m Metadata) ([]byte, error) {
+	if len(jpeg) < 4 || jpeg[0] != 0xff || jpeg[1] != 0xd8 {
+		return nil, errors.New("not a jpeg image")
+	}
+	tiff, err := build(m)
+	if err != nil {
+		return nil, err
+	}
+	if len(tiff)+len(exifHeader)+2 > math.MaxUint16 {
+		return nil, errors.New("exif too large")
+	}
+
+	res := make([]byte, 0, len(jpeg)+len(tiff)+12)
+	res = append(res, 0xff, 0xd8, 0xff, 0xe1)
+	res = binary.BigEndian.AppendUint16(res, uint16(len(tiff)+len(exifHeader)+2))
+	res = append(res, exifHeader...)
+	res = append(res, tiff...)
+	// 复制其余段，跳过旧的 EXIF
+	i := 2
+	for i+4 <= len(jpeg) && jpeg[i] == 0xff && jpeg[i+1] != 0xda {
+		l := int(binary.BigEndian.Uint16(jpeg[i+2:]))
+		end := i + 2 + l
+		if end > len(jpeg) {
+			return nil, errors.New("corrupted jpeg segment")
+		}
+		if !(jpeg[i+1] == 0xe1 && bytes.HasPrefix(jpeg[i+4:end], exifHeader)) {
+			res = append(res, jpeg[i:end]...)
+		}
+		i = end
+	}
+
+	return append(res, jpeg[i:]...), nil
+}
+
+// Read 读取 JPEG 中的 EXIF，返回标签名到值的映射
+func Read(jpeg []byte) (map[string]any, error) {
+	tiff, err := find(jpeg)
+	if err != nil {
+		return nil, err
+	}
+	if len(tiff) < 8 {
+		return nil, errors.New("exif too short")
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("invalid tiff header")
+	}
+
+	res := make(map[string]any)
+	r := reader{data: tiff, order: order}
+	ifd0 := r.readIFD(order.Uint32(tiff[4:]))
+	sub := make(map[uint16]map[uint16]any)
+	for tag, v := range ifd0 {
+		if tag == tagExifIFD || tag == tagGPSIFD {
+			if off, ok := v.(uint32); ok {
+				sub[tag] = r.readIFD(off)
+			}
+			continue
+		}
+		res[name(tagNames, tag)] = v
+	}
+	for tag, v := range sub[tagExifIFD] {
+		res[name(tagNames, tag)] = v
+	}
+	for tag, v := range sub[tagGPSIFD] {
+		res[name(gpsTagNames, tag)] = v
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return res, nil
+}
+
+func name(names map[uint16]string, tag uint16) string {
+	if n, ok := names[tag]; ok {
+		return n
+	}
+
+	return fmt.Sprintf("0x%04x", tag)
+}
+
+func find(jpeg []byte) ([]byte, error) {
+	if len(jpeg) < 4 || jpeg[0] != 0xff || jpeg[1] != 0xd8 {
+		return nil, errors.New("not a jpeg image")
+	}
+	for i := 2; i+4 <= len(jpeg) && jpeg[i] == 0xff && jpeg[i+1] != 0xda; {
+		end := i + 2 + int(binary.BigEndian.Uint16(jpeg[i+2:]))
+		if end > len(jpeg) {
+			break
+		}
+		if jpeg[i+1] == 0xe1 && bytes.HasPrefix(jpeg[i+4:end], exifHeader) {
+			return jpeg[i+4+len(exifHeader) : end], nil
+		}
+		i = end
+	}
+
+	return nil, ErrNotFound
+}
+
+type entry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+type ifd []entry
+
+func (d *ifd) ascii(tag uint16, s string) {
+	if s == "" {
+		return
+	}
+	*d = append(*d, entry{tag: tag, typ: typeASCII, count: uint32(len(s) + 1), data: append([]byte(s), 0)})
+}
+
+func (d *ifd) short(tag uint16, v uint16) {
+	*d = append(*d, entry{tag: tag, typ: typeShort, count: 1, data: binary.LittleEndian.AppendUint16(nil, v)})
+}
+
+func (d *ifd) long(tag uint16, v uint32) {
+	*d = append(*d, entry{tag: tag, typ: typeLong, count: 1, data: binary.LittleEndian.AppendUint32(nil, v)})
+}
+
+func (d *ifd) rational(tag uint16, vs ...[2]uint32) {
+	var data []byte
+	for _, v := range vs {
+		data = binary.LittleEndian.AppendUint32(data, v[0])
+		data = binary.LittleEndian.AppendUint32(data, v[1])
+	}
+	*d = append(*d, entry{tag: tag, typ: typeRational, count: uint32(len(vs)), data: data})
+}
+
+func (d ifd) size() int {
+	n := 2 + 12*len(d) + 4
+	for _, e := range d {
+		if len(e.data) > 4 {
+			n += len(e.data) + len(e.data)%2
+		}
+	}
+
+	return n
+}
+
+// encode 以 off 为该 IFD 在 TIFF 中的偏移写出，TIFF 要求条目按标签升序排列
+func (d ifd) encode(buf []byte, off int) []byte {
+	sort.SliceStable(d, func(i, j int) bool { return d[i].tag < d[j].tag })
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(d)))
+	dataOff := off + 2 + 12*len(d) + 4
+	var data []byte
+	for _, e := range d {
+		buf = binary.LittleEndian.AppendUint16(buf, e.tag)
+		buf = binary.LittleEndian.AppendUint16(buf, e.typ)
+		buf = binary.LittleEndian.AppendUint32(buf, e.count)
+		if len(e.data) <= 4 {
+			v := make([]byte, 4)
+			copy(v, e.data)
+			buf = append(buf, v...)
+			continue
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(dataOff+len(data)))
+		data = append(data, e.data...)
+		if len(e.data)%2 == 1 {
+			data = append(data, 0)
+		}
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, 0)
+
+	return append(buf, data...)
+}
+
+func build(m Metadata) ([]byte, error) {
+	var ifd0, exifIFD, gpsIFD ifd
+	ifd0.ascii(tagImageDescription, m.Project)
+	ifd0.ascii(tagModel, m.Device)
+	ifd0.ascii(tagSoftware, software)
+	if !m.Time.IsZero() {
+		ifd0.ascii(tagDateTime, m.Time.Format(dateLayout))
+		exifIFD.ascii(tagDateTimeOriginal, m.Time.Format(dateLayout))
+	}
+	ifd0.ascii(tagHostComputer, m.Host)
+
+	if m.ExposureTime > 0 {
+		exifIFD.rational(tagExposureTime, [2]uint32{uint32(m.ExposureTime / time.Microsecond), 1000000})
+	}
+	if m.ISO > 0 {
+		exifIFD.short(tagISO, uint16(min(m.ISO, math.MaxUint16)))
+	}
+	exifIFD.long(tagImageNumber, uint32(m.Sequence))
+	if m.ExposureMode != ModeUnknown {
+		exifIFD.short(tagExposureMode, uint16(m.ExposureMode-ModeAuto))
+	}
+	if m.WhiteBalance != ModeUnknown {
+		exifIFD.short(tagWhiteBalance, uint16(m.WhiteBalance-ModeAuto))
+	}
+
+	if l := m.Location; l != nil {
+		gpsIFD = append(gpsIFD, entry{tag: tagGPSVersionID, typ: typeByte, count: 4, data: []byte{2, 3, 0, 0}})
+		latRef, lngRef := "N", "E"
+		if l.Latitude < 0 {
+			latRef = "S"
+		}
+		if l.Longitude < 0 {
+			lngRef = "W"
+		}
+		gpsIFD.ascii(tagGPSLatitudeRef, latRef)
+		gpsIFD.rational(tagGPSLatitude, degrees(l.Latitude)...)
+		gpsIFD.ascii(tagGPSLongitudeRef, lngRef)
+		gpsIFD.rational(tagGPSLongitude, degrees(l.Longitude)...)
+		var altRef byte
+		if l.Altitude < 0 {
+			altRef = 1
+		}
+		gpsIFD = append(gpsIFD, entry{tag: tagGPSAltitudeRef, typ: typeByte, count: 1, data: []byte{altRef}})
+		gpsIFD.rational(tagGPSAltitude, [2]uint32{uint32(math.Round(math.Abs(l.Altitude) * 100)), 100})
+	}
+
+	// 先占位子 IFD 指针，计算好偏移后再填写
+	ifd0.long(tagExifIFD, 0)
+	if len(gpsIFD) > 0 {
+		ifd0.long(tagGPSIFD, 0)
+	}
+	exifOff := 8 + ifd0.size()
+	gpsOff := exifOff + exifIFD.size()
+	for i := range ifd0 {
+		switch ifd0[i].tag {
+		case tagExifIFD:
+			ifd0[i].data = binary.LittleEndian.AppendUint32(nil, uint32(exifOff))
+		case tagGPSIFD:
+			ifd0[i].data = binary.LittleEndian.AppendUint32(nil, uint32(gpsOff))
+		}
+	}
+
+	buf := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	buf = ifd0.encode(buf, 8)
+	buf = exifIFD.encode(buf, exifOff)
+	if len(gpsIFD) > 0 {
+		buf = gpsIFD.encode(buf, gpsOff)
+	}
+
+	return buf, nil
+}
+
+// degrees 将十进制度数转换为 度/分/秒 三个有理数
+func degrees(v float64) [][2]uint32 {
+	v = math.Abs(v)
+	d := math.Floor(v)
+	m := math.Floor((v - d) * 60)
+	s := (v - d - m/60) * 3600
+
+	return [][2]uint32{{uint32(d), 1}, {uint32(m), 1}, {uint32(math.Round(s * 1000)), 1000}}
+}
+
+type reader struct {
+	data  []byte
+	order binary.ByteOrder
+	err   error
+}
+
+// readIFD 读取 off 处的 IFD。偏移与数量来自文件，按 uint64 检查范围，避免 32 位平台上转换为 int 后溢出
+func (r *reader) readIFD(off uint32) map[uint16]any {
+	res := make(map[uint16]any)
+	total := uint64(len(r.data))
+	if uint64(off)+2 > total {
+		r.err = errors.New("ifd offset out of range")
+		return res
+	}
+	n := uint64(r.order.Uint16(r.data[off:]))
+	for i := uint64(0); i < n; i++ {
+		p := uint64(off) + 2 + 12*i
+		if p+12 > total {
+			r.err = errors.New("ifd entry out of range")
+			return res
+		}
+		tag := r.order.Uint16(r.data[p:])
+		typ := r.order.Uint16(r.data[p+2:])
+		count := uint64(r.order.Uint32(r.data[p+4:]))
+		size := count * uint64(typeSize(typ))
+		if size == 0 || size > total {
+			continue
+		}
+		val := r.data[p+8 : p+12]
+		if size > 4 {
+			o := uint64(r.order.Uint32(val))
+			if o+size > total {
+				continue
+			}
+			val = r.data[o : o+size]
+		}
+		res[tag] = r.value(typ, int(count), val[:size])
+	}
+
+	return res
+}
+
+func (r *reader) value(typ uint16, count int, val []byte) any {
+	switch typ {
+	case typeASCII:
+		return string(bytes.TrimRight(val, "\x00"))
+	case typeShort:
+		vs := make([]uint32, count)
+		for i := range vs {
+			vs[i] = uint32(r.order.Uint16(val[2*i:]))
+		}
+		return single(vs)
+	case typeLong:
+		vs := make([]uint32, count)
+		for i := range vs {
+			vs[i] = r.order.Uint32(val[4*i:])
+		}
+		return single(vs)
+	case typeRational:
+		vs := make([]float64, count)
+		for i := range vs {
+			num, den := r.order.Uint32(val[8*i:]), r.order.Uint32(val[8*i+4:])
+			if den != 0 {
+				vs[i] = float64(num) / float64(den)
+			}
+		}
+		return single(vs)
+	default:
+		return single(val)
+	}
+}
+
+func single[T any](vs []T) any {
+	if len(vs) == 1 {
+		return vs[0]
+	}
+
+	return vs
+}
+
+func typeSize(typ uint16) int {
+	switch typ {
+	case typeByte, typeASCII, 7:
+		return 1
+	case typeShort:
+		return 2
+	case typeLong:
+		return 4
+	case typeRational, 10:
+		return 8
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,126 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"math"
+	"testing"
+	"time"
+
+	"plant-shutter-pi/pkg/types"
+)
+
+func TestWriteRead(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	m := Metadata{
+		Time:         time.Date(2024, 5, 1, 8, 30, 0, 0, time.Local),
+		Project:      "tomato",
+		Sequence:     42,
+		Host:         "raspberrypi",
+		ExposureTime: 10 * time.Millisecond,
+		ExposureMode: ModeManual,
+		WhiteBalance: ModeAuto,
+		ISO:          200,
+		Location:     &types.Location{Latitude: 31.5, Longitude: -121.25, Altitude: 12.5},
+	}
+	data, err := Write(buf.Bytes(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 再次写入应替换而不是追加
+	if data, err = Write(data, m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("decode jpeg with exif: %s", err)
+	}
+	if n := bytes.Count(data, exifHeader); n != 1 {
+		t.Fatalf("got %d exif segments", n)
+	}
+
+	res, err := Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"ImageDescription": "tomato",
+		"DateTimeOriginal": "2024:05:01 08:30:00",
+		"HostComputer":     "raspberrypi",
+		"ImageNumber":      uint32(42),
+		"ISOSpeedRatings":  uint32(200),
+		"ExposureTime":     0.01,
+		"ExposureMode":     uint32(1),
+		"WhiteBalance":     uint32(0),
+		"GPSLatitudeRef":   "N",
+		"GPSLongitudeRef":  "W",
+		"GPSAltitude":      12.5,
+	}
+	for k, v := range want {
+		if res[k] != v {
+			t.Errorf("%s: got %v(%T), want %v", k, res[k], res[k], v)
+		}
+	}
+	lng, _ := res["GPSLongitude"].([]float64)
+	if len(lng) != 3 || math.Abs(lng[0]+lng[1]/60+lng[2]/3600-121.25) > 1e-6 {
+		t.Errorf("GPSLongitude: got %v", res["GPSLongitude"])
+	}
+
+	if _, err = Read(buf.Bytes()); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestTagOrder(t *testing.T) {
+	tiff, err := build(Metadata{
+		Time:         time.Now(),
+		ExposureTime: time.Millisecond,
+		ISO:          100,
+		ExposureMode: ModeAuto,
+		Location:     &types.Location{Latitude: 1, Longitude: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 每个 IFD 的条目需按标签升序排列
+	offs := []uint32{8}
+	for len(offs) > 0 {
+		off := int(offs[0])
+		offs = offs[1:]
+		var last uint16
+		for i := 0; i < int(binary.LittleEndian.Uint16(tiff[off:])); i++ {
+			e := tiff[off+2+12*i:]
+			tag := binary.LittleEndian.Uint16(e)
+			if i > 0 && tag <= last {
+				t.Fatalf("tag 0x%04x after 0x%04x", tag, last)
+			}
+			last = tag
+			if tag == tagExifIFD || tag == tagGPSIFD {
+				offs = append(offs, binary.LittleEndian.Uint32(e[8:]))
+			}
+		}
+	}
+}
+
+func TestReadCorrupt(t *testing.T) {
+	// 第一个条目的数量与偏移均为 0xFFFFFFFF，第二个条目数量正常、偏移越界
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0, 2, 0}
+	for _, count := range []uint32{0xFFFFFFFF, 2} {
+		tiff = binary.LittleEndian.AppendUint16(tiff, tagModel)
+		tiff = binary.LittleEndian.AppendUint16(tiff, typeLong)
+		tiff = binary.LittleEndian.AppendUint32(tiff, count)
+		tiff = binary.LittleEndian.AppendUint32(tiff, 0xFFFFFFFF)
+	}
+	tiff = binary.LittleEndian.AppendUint32(tiff, 0)
+	r := reader{data: tiff, order: binary.LittleEndian}
+	if res := r.readIFD(8); len(res) != 0 || r.err != nil {
+		t.Fatalf("got %v %v", res, r.err)
+	}
+	if r.readIFD(0xFFFFFFFF); r.err == nil {
+		t.Fatal("expected offset out of range")
+	}
+}
@@ -0,0 +1,262 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"plant-shutter-pi/pkg/imaging"
+	"plant-shutter-pi/pkg/overlay"
+	"plant-shutter-pi/pkg/types"
+)
+
+const (
+	KindContactSheet = "contact-sheet"
+	KindGIF          = "gif"
+	KindWebP         = "webp"
+	// KindVideo 由已保存的照片重新生成 MJPEG 视频
+	KindVideo = "video"
+
+	DefaultColumns    = 6
+	DefaultSheetWidth = 240
+	DefaultWidth      = 320
+	DefaultFPS        = 10
+
+	// 限制帧数以控制内存占用，超出时自动加大间隔
+	MaxSheetFrames     = 200
+	MaxAnimationFrames = 300
+
+	timeLayout = "2006-01-02 15:04"
+)
+
+// Frame 为参与导出的一张照片
+type Frame struct {
+	Name string
+	Time time.Time
+	Load func() ([]byte, error)
+}
+
+// Options 为导出参数
+type Options struct {
+	// Width 联系表每格或动图每帧的宽度，高度按比例计算
+	Width int
+	// Columns 联系表列数
+	Columns int
+	// FPS 动图帧率
+	FPS     int
+	Quality int
+}
+
+// Ext 返回导出文件的扩展名
+func Ext(kind string) (string, error) {
+	switch kind {
+	case KindContactSheet:
+		return ".jpg", nil
+	case KindGIF:
+		return ".gif", nil
+	case KindWebP:
+		return ".webp", nil
+	case KindVideo:
+		return ".avi", nil
+	}
+
+	return "", fmt.Errorf("unknown export type %s", kind)
+}
+
+// Select 从 n 张照片中选取 [start, end) 范围内每 every 张一张，超过 limit 张时加大间隔。
+// end 为 0 时表示到最后一张。
+func Select(n, start, end, every, limit int) []int {
+	if end <= 0 || end > n {
+		end = n
+	}
+	start = max(start, 0)
+	if start >= end {
+		return nil
+	}
+	every = max(every, 1)
+	if limit > 0 {
+		every = max(every, (end-start+limit-1)/limit)
+	}
+	res := make([]int, 0, (end-start+every-1)/every)
+	for i := start; i < end; i += every {
+		res = append(res, i)
+	}
+
+	return res
+}
+
+// ContactSheet 将 frames 缩小后排成网格并标注拍摄时间
+func ContactSheet(frames []Frame, o Options, progress func(done int)) ([]byte, error) {
+	if len(frames) == 0 {
+		return nil, errors.New("no images selected")
+	}
+	cellW := o.Width
+	if cellW <= 0 {
+		cellW = DefaultSheetWidth
+	}
+	cols := o.Columns
+	if cols <= 0 {
+		cols = DefaultColumns
+	}
+	cols = min(cols, len(frames))
+	rows := (len(frames) + cols - 1) / cols
+
+	var sheet *image.RGBA
+	cellH := 0
+	label := types.OverlaySetting{Position: "bottom-left", FontSize: max(10, cellW/20)}
+	for i, f := range frames {
+		img, err := load(f)
+		if err != nil {
+			return nil, err
+		}
+		if sheet == nil {
+			// 格子高度由第一张照片的比例决定
+			b := img.Bounds()
+			cellH = max(1, b.Dy()*cellW/b.Dx())
+			sheet = image.NewRGBA(image.Rect(0, 0, cols*cellW, rows*cellH))
+			draw.Draw(sheet, sheet.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+		}
+		x, y := i%cols*cellW, i/cols*cellH
+		cell := sheet.SubImage(image.Rect(x, y, x+cellW, y+cellH)).(*image.RGBA)
+		draw.Draw(cell, cell.Bounds(), imaging.Resize(img, cellW, cellH), image.Point{}, draw.Src)
+		if err = overlay.Draw(cell, []string{f.Time.Format(timeLayout)}, label); err != nil {
+			return nil, err
+		}
+		if progress != nil {
+			progress(i + 1)
+		}
+	}
+
+	return imaging.Encode(sheet, o.Quality)
+}
+
+// GIF 将 frames 编码为循环播放的 GIF 动图
+func GIF(w io.Writer, frames []Frame, o Options, progress func(done int)) error {
+	if len(frames) == 0 {
+		return errors.New("no images selected")
+	}
+	width := o.Width
+	if width <= 0 {
+		width = DefaultWidth
+	}
+	fps := o.FPS
+	if fps <= 0 {
+		fps = DefaultFPS
+	}
+	delay := max(2, 100/fps)
+
+	anim := &gif.GIF{}
+	for i, f := range frames {
+		img, err := load(f)
+		if err != nil {
+			return err
+		}
+		b := img.Bounds()
+		w, h := imaging.FitSize(b.Dx(), b.Dy(), width, 0)
+		dst := image.NewPaletted(image.Rect(0, 0, w, h), palette.Plan9)
+		draw.FloydSteinberg.Draw(dst, dst.Bounds(), imaging.Resize(img, w, h), image.Point{})
+		anim.Image = append(anim.Image, dst)
+		anim.Delay = append(anim.Delay, delay)
+		if progress != nil {
+			progress(i + 1)
+		}
+	}
+
+	return gif.EncodeAll(w, anim)
+}
+
+// WebP 调用 ffmpeg 将 frames 编码为循环播放的 WebP 动图。
+// Go 没有 WebP 编码器，需要安装带 libwebp 的 ffmpeg。
+func WebP(output string, frames []Frame, o Options, progress func(done int)) error {
+	if len(frames) == 0 {
+		return errors.New("no images selected")
+	}
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return fmt.Errorf("webp export needs ffmpeg: %w", err)
+	}
+	width := o.Width
+	if width <= 0 {
+		width = DefaultWidth
+	}
+	fps := o.FPS
+	if fps <= 0 {
+		fps = DefaultFPS
+	}
+	quality := o.Quality
+	if quality <= 0 {
+		quality = imaging.DefaultQuality
+	}
+	cmd := exec.Command(ffmpeg,
+		"-loglevel", "error",
+		"-f", "mjpeg", "-framerate", strconv.Itoa(fps), "-i", "-",
+		"-vf", fmt.Sprintf("scale=%d:-2", width&^1),
+		"-c:v", "libwebp_anim", "-loop", "0", "-q:v", strconv.Itoa(quality),
+		"-y", output,
+	)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	out := &limitedBuffer{}
+	cmd.Stderr = out
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	for i, f := range frames {
+		var data []byte
+		if data, err = f.Load(); err != nil {
+			break
+		}
+		if _, err = stdin.Write(data); err != nil {
+			break
+		}
+		if progress != nil {
+			progress(i + 1)
+		}
+	}
+	_ = stdin.Close()
+	if werr := cmd.Wait(); werr != nil && err == nil {
+		err = fmt.Errorf("ffmpeg: %w, %s", werr, out.String())
+	}
+
+	return err
+}
+
+func load(f Frame) (image.Image, error) {
+	data, err := f.Load()
+	if err != nil {
+		return nil, err
+	}
+	img, err := imaging.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s err: %w", f.Name, err)
+	}
+
+	return img, nil
+}
+
+// limitedBuffer 只保留 ffmpeg 错误输出的前 1KB
+type limitedBuffer struct {
+	buf []byte
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if n := 1024 - len(b.buf); n > 0 {
+		b.buf = append(b.buf, p[:min(n, len(p))]...)
+	}
+
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	return string(b.buf)
+}
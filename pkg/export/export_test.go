@@ -0,0 +1,62 @@
+package export
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSelect(t *testing.T) {
+	if got := Select(10, 2, 8, 2, 0); !slices.Equal(got, []int{2, 4, 6}) {
+		t.Fatalf("got %v", got)
+	}
+	// 超过上限时加大间隔
+	if got := Select(10, 0, 0, 1, 3); !slices.Equal(got, []int{0, 4, 8}) {
+		t.Fatalf("got %v", got)
+	}
+	if got := Select(10, 10, 0, 1, 0); len(got) != 0 {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestRender(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 400, 300)), nil); err != nil {
+		t.Fatal(err)
+	}
+	frames := make([]Frame, 5)
+	for i := range frames {
+		frames[i] = Frame{Time: time.Now(), Load: func() ([]byte, error) {
+			return buf.Bytes(), nil
+		}}
+	}
+
+	sheet, err := ContactSheet(frames, Options{Width: 100, Columns: 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(sheet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 200 || cfg.Height != 225 {
+		t.Fatalf("got sheet %d*%d", cfg.Width, cfg.Height)
+	}
+
+	var out bytes.Buffer
+	done := 0
+	if err = GIF(&out, frames, Options{Width: 80}, func(n int) { done = n }); err != nil {
+		t.Fatal(err)
+	}
+	anim, err := gif.DecodeAll(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != 5 || done != 5 || anim.Config.Width != 80 {
+		t.Fatalf("got %d frames, width %d, progress %d", len(anim.Image), anim.Config.Width, done)
+	}
+}
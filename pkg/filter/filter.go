@@ -0,0 +1,85 @@
+package filter
+
+import (
+	"image"
+
+	"plant-shutter-pi/pkg/imaging"
+	"plant-shutter-pi/pkg/types"
+)
+
+const (
+	ReasonDark      = "dark"
+	ReasonUnchanged = "unchanged"
+
+	// 比较时先缩小到 32x24，忽略噪点和细微的抖动
+	sigWidth  = 32
+	sigHeight = 24
+)
+
+// Signature 为照片缩小后的亮度图
+type Signature struct {
+	Luma []uint8
+	// Brightness 平均亮度 0-255
+	Brightness float64
+}
+
+// Compute 计算 JPEG 照片的亮度图
+func Compute(frame []byte) (*Signature, error) {
+	img, err := imaging.Decode(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	return FromImage(img), nil
+}
+
+func FromImage(img image.Image) *Signature {
+	small := imaging.Resize(img, sigWidth, sigHeight)
+	rect := small.Bounds()
+	sig := &Signature{Luma: make([]uint8, 0, sigWidth*sigHeight)}
+	var sum int
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			r, g, b, _ := small.At(x, y).RGBA()
+			l := (299*r + 587*g + 114*b) / 1000 >> 8
+			sig.Luma = append(sig.Luma, uint8(l))
+			sum += int(l)
+		}
+	}
+	sig.Brightness = float64(sum) / float64(len(sig.Luma))
+
+	return sig
+}
+
+// Diff 返回两张亮度图的平均差值 0-255
+func Diff(a, b *Signature) float64 {
+	if len(a.Luma) != len(b.Luma) || len(a.Luma) == 0 {
+		return 255
+	}
+	var sum int
+	for i := range a.Luma {
+		d := int(a.Luma[i]) - int(b.Luma[i])
+		if d < 0 {
+			d = -d
+		}
+		sum += d
+	}
+
+	return float64(sum) / float64(len(a.Luma))
+}
+
+// Check 按设置 s 判断 cur 是否应被过滤，返回过滤原因（为空时保留）与相对 last 的变化量。
+// last 为 nil 时不做变化比较。
+func Check(s types.FilterSetting, last, cur *Signature) (reason string, change float64) {
+	if last != nil {
+		change = Diff(last, cur)
+	}
+	if s.MinBrightness > 0 && cur.Brightness < s.MinBrightness {
+		return ReasonDark, change
+	}
+	if s.Threshold > 0 && last != nil && change < s.Threshold {
+		return ReasonUnchanged, change
+	}
+
+	return "", change
+}
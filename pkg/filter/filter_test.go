@@ -0,0 +1,38 @@
+package filter
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"plant-shutter-pi/pkg/types"
+)
+
+func uniform(v uint8) *Signature {
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{Y: v}), image.Point{}, draw.Src)
+
+	return FromImage(img)
+}
+
+func TestCheck(t *testing.T) {
+	s := types.FilterSetting{Enable: true, Threshold: 3, MinBrightness: 20}
+	dark, grey, light := uniform(5), uniform(128), uniform(140)
+	if grey.Brightness != 128 {
+		t.Fatalf("got brightness %f", grey.Brightness)
+	}
+
+	if reason, _ := Check(s, nil, dark); reason != ReasonDark {
+		t.Fatalf("got %q, want dark", reason)
+	}
+	if reason, _ := Check(s, nil, grey); reason != "" {
+		t.Fatalf("first frame should be kept, got %q", reason)
+	}
+	if reason, change := Check(s, grey, uniform(129)); reason != ReasonUnchanged || change != 1 {
+		t.Fatalf("got %q %f, want unchanged", reason, change)
+	}
+	if reason, change := Check(s, grey, light); reason != "" || change != 12 {
+		t.Fatalf("got %q %f, want kept", reason, change)
+	}
+}
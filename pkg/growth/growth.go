@@ -0,0 +1,164 @@
+package growth
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	"plant-shutter-pi/pkg/imaging"
+	"plant-shutter-pi/pkg/types"
+)
+
+const (
+	// ROIAll 未配置分析区域时整张照片的名称
+	ROIAll = "all"
+
+	defaultWidth     = 640
+	defaultThreshold = 20
+	defaultHueMin    = 60
+	defaultHueMax    = 180
+	// 一行/列中至少有这么多叶片像素才计入高度和宽度，忽略零星噪点
+	minRun = 2
+)
+
+// Result 为一个分析区域的测量结果，单位均为原图像素
+type Result struct {
+	ROI      string `json:"roi"`
+	LeafArea int    `json:"leafArea"`
+	// Height、Width 为叶片外接矩形的高和宽
+	Height int `json:"height"`
+	Width  int `json:"width"`
+	// Coverage 叶片像素占区域的比例 0-1
+	Coverage float64 `json:"coverage"`
+}
+
+// Analyze 按设置 s 分割 img 中的绿色叶片并测量每个区域
+func Analyze(img image.Image, s types.GrowthSetting) []Result {
+	b := img.Bounds()
+	width := s.Width
+	if width <= 0 {
+		width = defaultWidth
+	}
+	width = min(width, b.Dx())
+	w, h := imaging.FitSize(b.Dx(), b.Dy(), width, 0)
+	small := toRGBA(imaging.Resize(img, w, h))
+	scale := float64(b.Dx()) / float64(w)
+
+	rois := s.ROIs
+	if len(rois) == 0 {
+		rois = []types.GrowthROI{{Name: ROIAll, Rect: types.Rect{Width: b.Dx(), Height: b.Dy()}}}
+	}
+	green := classifier(s)
+	res := make([]Result, 0, len(rois))
+	for _, roi := range rois {
+		r := image.Rect(
+			int(float64(roi.Rect.Left)/scale), int(float64(roi.Rect.Top)/scale),
+			int(math.Ceil(float64(roi.Rect.Left+roi.Rect.Width)/scale)), int(math.Ceil(float64(roi.Rect.Top+roi.Rect.Height)/scale)),
+		).Intersect(small.Rect)
+		res = append(res, measure(small, r, scale, roi.Name, green))
+	}
+
+	return res
+}
+
+func measure(img *image.RGBA, r image.Rectangle, scale float64, name string, green func(r, g, b int) bool) Result {
+	res := Result{ROI: name}
+	if r.Empty() {
+		return res
+	}
+	rows := make([]int, r.Dy())
+	cols := make([]int, r.Dx())
+	count := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := img.PixOffset(r.Min.X, y)
+		for x := r.Min.X; x < r.Max.X; x, i = x+1, i+4 {
+			if green(int(img.Pix[i]), int(img.Pix[i+1]), int(img.Pix[i+2])) {
+				count++
+				rows[y-r.Min.Y]++
+				cols[x-r.Min.X]++
+			}
+		}
+	}
+	res.LeafArea = int(math.Round(float64(count) * scale * scale))
+	res.Height = int(math.Round(float64(span(rows)) * scale))
+	res.Width = int(math.Round(float64(span(cols)) * scale))
+	res.Coverage = float64(count) / float64(r.Dx()*r.Dy())
+
+	return res
+}
+
+// span 返回第一个与最后一个达到 minRun 的位置之间的长度
+func span(counts []int) int {
+	first, last := -1, -1
+	for i, c := range counts {
+		if c >= minRun {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return 0
+	}
+
+	return last - first + 1
+}
+
+func classifier(s types.GrowthSetting) func(r, g, b int) bool {
+	if s.Method == types.GrowthHSV {
+		hueMin, hueMax := s.HueMin, s.HueMax
+		if hueMin == 0 && hueMax == 0 {
+			hueMin, hueMax = defaultHueMin, defaultHueMax
+		}
+		return func(r, g, b int) bool {
+			h, sat, v := hsv(r, g, b)
+			return h >= float64(hueMin) && h <= float64(hueMax) && sat >= s.SatMin && v >= s.ValMin
+		}
+	}
+	threshold := s.Threshold
+	if threshold <= 0 {
+		threshold = defaultThreshold
+	}
+
+	return func(r, g, b int) bool {
+		return 2*g-r-b >= threshold
+	}
+}
+
+// hsv 返回色相(0-360)、饱和度与明度(0-255)
+func hsv(r, g, b int) (float64, int, int) {
+	maxC, minC := max(r, g, b), min(r, g, b)
+	d := maxC - minC
+	if maxC == 0 {
+		return 0, 0, 0
+	}
+	sat := d * 255 / maxC
+	if d == 0 {
+		return 0, sat, maxC
+	}
+	var h float64
+	switch maxC {
+	case r:
+		h = 60 * math.Mod(float64(g-b)/float64(d), 6)
+	case g:
+		h = 60 * (float64(b-r)/float64(d) + 2)
+	default:
+		h = 60 * (float64(r-g)/float64(d) + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+
+	return h, sat, maxC
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	return dst
+}
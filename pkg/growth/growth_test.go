@@ -0,0 +1,43 @@
+package growth
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"plant-shutter-pi/pkg/types"
+)
+
+func TestAnalyze(t *testing.T) {
+	// 200x100 的棕色背景上有一块 20x40 的绿色叶片
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{R: 120, G: 90, B: 60, A: 255}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(40, 50, 60, 90), image.NewUniform(color.RGBA{R: 40, G: 160, B: 50, A: 255}), image.Point{}, draw.Src)
+
+	for _, method := range []string{types.GrowthExG, types.GrowthHSV} {
+		res := Analyze(img, types.GrowthSetting{
+			Method: method,
+			ROIs: []types.GrowthROI{
+				{Name: "left", Rect: types.Rect{Width: 100, Height: 100}},
+				{Name: "right", Rect: types.Rect{Left: 100, Width: 100, Height: 100}},
+			},
+		})
+		if len(res) != 2 {
+			t.Fatalf("%s: got %d results", method, len(res))
+		}
+		left := res[0]
+		if left.LeafArea != 800 || left.Height != 40 || left.Width != 20 || left.Coverage != 0.08 {
+			t.Fatalf("%s: got %+v", method, left)
+		}
+		if res[1].LeafArea != 0 || res[1].Height != 0 {
+			t.Fatalf("%s: got %+v", method, res[1])
+		}
+	}
+
+	// 缩小分析后换算回原图像素
+	res := Analyze(img, types.GrowthSetting{Width: 100})
+	if res[0].ROI != ROIAll || res[0].LeafArea != 800 || res[0].Height != 40 {
+		t.Fatalf("got %+v", res[0])
+	}
+}
@@ -0,0 +1,77 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+
+	"golang.org/x/image/draw"
+
+	"plant-shutter-pi/pkg/types"
+)
+
+const DefaultQuality = 90
+
+// Decode 解码 JPEG 照片
+func Decode(data []byte) (image.Image, error) {
+	return jpeg.Decode(bytes.NewReader(data))
+}
+
+// Encode 编码为 JPEG，quality 为 0 时使用默认质量
+func Encode(img image.Image, quality int) ([]byte, error) {
+	if quality <= 0 {
+		quality = DefaultQuality
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Crop 裁剪 r 区域，超出图像的部分被忽略
+func Crop(img image.Image, r types.Rect) (image.Image, error) {
+	rect := image.Rect(r.Left, r.Top, r.Left+r.Width, r.Top+r.Height).
+		Add(img.Bounds().Min).
+		Intersect(img.Bounds())
+	if rect.Empty() {
+		return nil, fmt.Errorf("crop %+v is outside of the image %v", r, img.Bounds().Size())
+	}
+	if s, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(rect), nil
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+
+	return dst, nil
+}
+
+// Resize 缩放到 width*height，尺寸相同时直接返回原图
+func Resize(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	if b.Dx() == width && b.Dy() == height {
+		return img
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+
+	return dst
+}
+
+// FitSize 计算输出尺寸：都为 0 时保持原尺寸，只指定一边时按比例计算另一边
+func FitSize(srcW, srcH, width, height int) (int, int) {
+	switch {
+	case width <= 0 && height <= 0:
+		return srcW, srcH
+	case width <= 0:
+		width = max(1, srcW*height/srcH)
+	case height <= 0:
+		height = max(1, srcH*width/srcW)
+	}
+
+	return width, height
+}
@@ -0,0 +1,40 @@
+package imaging
+
+import (
+	"image"
+	"testing"
+
+	"plant-shutter-pi/pkg/types"
+)
+
+func TestCropResize(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 64, 48), image.YCbCrSubsampleRatio420)
+
+	img, err := Crop(src, types.Rect{Left: 40, Top: 8, Width: 40, Height: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 超出部分被裁掉
+	if got := img.Bounds().Size(); got != image.Pt(24, 16) {
+		t.Fatalf("crop size %v", got)
+	}
+	if _, err = Crop(src, types.Rect{Left: 64, Top: 0, Width: 8, Height: 8}); err == nil {
+		t.Fatal("expected error for crop outside of the image")
+	}
+
+	w, h := FitSize(24, 16, 48, 0)
+	if w != 48 || h != 32 {
+		t.Fatalf("fit size %d*%d", w, h)
+	}
+	if got := Resize(img, w, h).Bounds().Size(); got != image.Pt(48, 32) {
+		t.Fatalf("resize size %v", got)
+	}
+
+	data, err := Encode(img, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Decode(data); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,343 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"go.uber.org/zap"
+
+	"plant-shutter-pi/pkg/storage/consts"
+	"plant-shutter-pi/pkg/utils"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusRunning  = "running"
+	StatusDone     = "done"
+	StatusFailed   = "failed"
+	StatusCanceled = "canceled"
+
+	// 保留的已结束任务数
+	maxFinished = 50
+)
+
+var logger *zap.SugaredLogger
+
+func init() {
+	logger = utils.GetLogger()
+}
+
+// Job 为一个后台任务，Params 为提交时的参数，重启后按参数重新执行
+type Job struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Project string `json:"project"`
+	// Priority 越大越先执行，相同时按提交顺序
+	Priority int             `json:"priority"`
+	Params   json.RawMessage `json:"params,omitempty"`
+	Output   string          `json:"output,omitempty"`
+	Status   string          `json:"status"`
+	Progress float64         `json:"progress"`
+	Error    string          `json:"error,omitempty"`
+	CreateAt time.Time       `json:"createAt"`
+	StartAt  *time.Time      `json:"startAt,omitempty"`
+	FinishAt *time.Time      `json:"finishAt,omitempty"`
+}
+
+func (j *Job) finished() bool {
+	return j.Status == StatusDone || j.Status == StatusFailed || j.Status == StatusCanceled
+}
+
+// RunFunc 执行任务，通过 progress 汇报 0-1 的进度；ctx 取消时应尽快返回
+type RunFunc func(ctx context.Context, job Job, progress func(float64)) error
+
+// Queue 按优先级依次执行后台任务，同一时间只运行一个，避免占满树莓派的 CPU。
+// 任务状态保存在 file 中，未完成的任务在重启后重新执行。
+type Queue struct {
+	file string
+	run  RunFunc
+
+	lock sync.Mutex
+	// cond 在有新任务、拍摄结束或任务取消时唤醒等待者
+	cond *sync.Cond
+	jobs []*Job
+	seq  int
+	// cancel 取消正在运行的任务
+	cancel  context.CancelFunc
+	running *Job
+	// paused 正在进行的拍摄数，大于 0 时暂停后台任务
+	paused int
+}
+
+// New 读取 file 中保存的任务并在后台开始执行，ctx 结束时停止
+func New(ctx context.Context, file string, run RunFunc) (*Queue, error) {
+	q := &Queue{
+		file: file,
+		run:  run,
+	}
+	q.cond = sync.NewCond(&q.lock)
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		q.lock.Lock()
+		q.cond.Broadcast()
+		q.lock.Unlock()
+	}()
+	go q.work(ctx)
+
+	return q, nil
+}
+
+// Add 提交任务，params 不为 nil 时编码为 JSON 保存
+func (q *Queue) Add(typ, project string, priority int, params any, output string) (Job, error) {
+	var data []byte
+	if params != nil {
+		var err error
+		if data, err = json.Marshal(params); err != nil {
+			return Job{}, err
+		}
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.seq++
+	j := &Job{
+		ID:       fmt.Sprintf("%d-%d", time.Now().UnixMilli(), q.seq),
+		Type:     typ,
+		Project:  project,
+		Priority: priority,
+		Params:   data,
+		Output:   output,
+		Status:   StatusPending,
+		CreateAt: time.Now(),
+	}
+	q.jobs = append(q.jobs, j)
+	q.save()
+	q.cond.Broadcast()
+
+	return *j, nil
+}
+
+// Get 返回 id 对应的任务
+func (q *Queue) Get(id string) (Job, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if j := q.find(id); j != nil {
+		return *j, true
+	}
+
+	return Job{}, false
+}
+
+// List 返回 project 的任务，project 为空时返回全部
+func (q *Queue) List(project string) []Job {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	res := make([]Job, 0)
+	for _, j := range q.jobs {
+		if project == "" || j.Project == project {
+			res = append(res, *j)
+		}
+	}
+
+	return res
+}
+
+// Cancel 取消等待中或正在运行的任务，已结束的任务从列表中移除
+func (q *Queue) Cancel(id string) (Job, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	j := q.find(id)
+	if j == nil {
+		return Job{}, errors.New("job not found")
+	}
+	switch {
+	case j == q.running:
+		// 任务返回后状态变为 canceled
+		q.cancel()
+	case j.Status == StatusPending:
+		now := time.Now()
+		j.Status, j.FinishAt = StatusCanceled, &now
+	default:
+		q.remove(func(job *Job) bool { return job == j })
+	}
+	q.save()
+	q.cond.Broadcast()
+
+	return *j, nil
+}
+
+// Clear 移除所有已结束的任务
+func (q *Queue) Clear() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.remove((*Job).finished)
+	q.save()
+}
+
+// Pause 开始拍摄，后台任务在下一次汇报进度时暂停，直到所有拍摄结束
+func (q *Queue) Pause() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.paused++
+}
+
+// Resume 拍摄结束
+func (q *Queue) Resume() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.paused = max(q.paused-1, 0)
+	q.cond.Broadcast()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		q.lock.Lock()
+		var j *Job
+		for {
+			if ctx.Err() != nil {
+				q.lock.Unlock()
+				return
+			}
+			if j = q.next(); j != nil && q.paused == 0 {
+				break
+			}
+			q.cond.Wait()
+		}
+		jctx, cancel := context.WithCancel(ctx)
+		start := time.Now()
+		j.Status, j.StartAt, j.Progress = StatusRunning, &start, 0
+		q.running, q.cancel = j, cancel
+		q.save()
+		job := *j
+		q.lock.Unlock()
+
+		err := q.run(jctx, job, func(p float64) {
+			q.lock.Lock()
+			defer q.lock.Unlock()
+			j.Progress = p
+			for q.paused > 0 && jctx.Err() == nil {
+				q.cond.Wait()
+			}
+		})
+
+		q.lock.Lock()
+		now := time.Now()
+		switch {
+		case ctx.Err() != nil:
+			// 程序退出，重启后重新执行
+			j.Status, j.StartAt, j.Progress = StatusPending, nil, 0
+		case jctx.Err() != nil:
+			j.Status, j.FinishAt = StatusCanceled, &now
+		case err != nil:
+			logger.Errorf("job %s %s of %s err: %s", j.ID, j.Type, j.Project, err)
+			j.Status, j.Error, j.FinishAt = StatusFailed, err.Error(), &now
+		default:
+			j.Status, j.Progress, j.FinishAt = StatusDone, 1, &now
+		}
+		cancel()
+		q.running, q.cancel = nil, nil
+		q.prune()
+		q.save()
+		q.lock.Unlock()
+	}
+}
+
+// next 返回优先级最高的等待中的任务
+func (q *Queue) next() *Job {
+	var res *Job
+	for _, j := range q.jobs {
+		if j.Status == StatusPending && (res == nil || j.Priority > res.Priority) {
+			res = j
+		}
+	}
+
+	return res
+}
+
+func (q *Queue) find(id string) *Job {
+	for _, j := range q.jobs {
+		if j.ID == id {
+			return j
+		}
+	}
+
+	return nil
+}
+
+func (q *Queue) remove(fn func(j *Job) bool) {
+	res := q.jobs[:0]
+	for _, j := range q.jobs {
+		if j == q.running || !fn(j) {
+			res = append(res, j)
+		}
+	}
+	q.jobs = res
+}
+
+func (q *Queue) prune() {
+	finished := 0
+	for _, j := range q.jobs {
+		if j.finished() {
+			finished++
+		}
+	}
+	q.remove(func(j *Job) bool {
+		if j.finished() && finished > maxFinished {
+			finished--
+			return true
+		}
+		return false
+	})
+}
+
+func (q *Queue) load() error {
+	data, err := os.ReadFile(q.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, &q.jobs); err != nil {
+		return fmt.Errorf("unmarshal jobs err: %w", err)
+	}
+	for _, j := range q.jobs {
+		// 上次退出时正在运行的任务从头开始
+		if j.Status == StatusRunning {
+			j.Status, j.StartAt, j.Progress = StatusPending, nil, 0
+		}
+	}
+
+	return nil
+}
+
+// save 先写入临时文件再重命名，断电时不会留下不完整的文件
+func (q *Queue) save() {
+	data, err := json.Marshal(q.jobs)
+	if err != nil {
+		logger.Errorf("marshal jobs err: %s", err)
+		return
+	}
+	tmp := path.Join(path.Dir(q.file), "."+path.Base(q.file))
+	if err = os.WriteFile(tmp, data, consts.DefaultFilePerm); err == nil {
+		err = os.Rename(tmp, q.file)
+	}
+	if err != nil {
+		logger.Errorf("save jobs err: %s", err)
+	}
+}
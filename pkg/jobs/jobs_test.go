@@ -0,0 +1,97 @@
+package jobs
+
+import (
+	"context"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func waitStatus(t *testing.T, q *Queue, id, status string) Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if j, ok := q.Get(id); ok && j.Status == status {
+			return j
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	j, _ := q.Get(id)
+	t.Fatalf("job %s is %s, want %s", id, j.Status, status)
+
+	return j
+}
+
+func TestQueue(t *testing.T) {
+	file := path.Join(t.TempDir(), "jobs.json")
+	ctx, cancel := context.WithCancel(context.Background())
+	var lock sync.Mutex
+	var order []string
+	q, err := New(ctx, file, func(ctx context.Context, job Job, progress func(float64)) error {
+		if job.Type == "block" {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		progress(0.5)
+		lock.Lock()
+		order = append(order, job.Project)
+		lock.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 拍摄期间不开始任务，结束后按优先级执行
+	q.Pause()
+	low, _ := q.Add("test", "low", 0, nil, "")
+	high, _ := q.Add("test", "high", 5, nil, "")
+	q.Resume()
+	waitStatus(t, q, low.ID, StatusDone)
+	if len(order) != 2 || order[0] != "high" || order[1] != "low" {
+		t.Fatalf("got order %v", order)
+	}
+	if j, _ := q.Get(high.ID); j.Progress != 1 {
+		t.Fatalf("got progress %v", j.Progress)
+	}
+
+	block, _ := q.Add("block", "a", 0, nil, "")
+	waitStatus(t, q, block.ID, StatusRunning)
+	if _, err = q.Cancel(block.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, q, block.ID, StatusCanceled)
+
+	// 退出时未完成的任务在重启后重新执行
+	q.Pause()
+	pending, _ := q.Add("test", "restart", 0, map[string]int{"n": 1}, "")
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan Job, 1)
+	q, err = New(ctx, file, func(ctx context.Context, job Job, progress func(float64)) error {
+		done <- job
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case j := <-done:
+		if j.ID != pending.ID || string(j.Params) != `{"n":1}` {
+			t.Fatalf("got %+v", j)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending job was not restored")
+	}
+	waitStatus(t, q, pending.ID, StatusDone)
+	if n := len(q.List("")); n != 4 {
+		t.Fatalf("got %d jobs", n)
+	}
+	q.Clear()
+	if n := len(q.List("")); n != 0 {
+		t.Fatalf("got %d jobs after clear", n)
+	}
+}
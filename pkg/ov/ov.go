@@ -3,8 +3,10 @@ package ov
 import (
 	"time"
 
+	"github.com/goccy/go-json"
 	"github.com/vladimirvivien/go4vl/v4l2"
 
+	"plant-shutter-pi/pkg/growth"
 	"plant-shutter-pi/pkg/storage/project"
 	"plant-shutter-pi/pkg/types"
 )
@@ -14,15 +16,37 @@ type NewProject struct {
 	Info     string              `json:"info"`
 	Interval *int                `json:"interval"`
 	Video    *types.VideoSetting `json:"video"`
+	// 摄像头 ID，为空时使用默认摄像头
+	Device  string               `json:"device"`
+	Capture types.CaptureSetting `json:"capture"`
+	// Parent 不为空时创建派生项目，必须同时指定 View
+	Parent string             `json:"parent"`
+	View   *types.ViewSetting `json:"view"`
+	// Location 拍摄地点，写入照片的 EXIF
+	Location *types.Location       `json:"location"`
+	Overlay  *types.OverlaySetting `json:"overlay"`
+	Filter   *types.FilterSetting  `json:"filter"`
+	Growth   *types.GrowthSetting  `json:"growth"`
+	// Calibration 灰卡颜色校正
+	Calibration *types.CalibrationSetting `json:"calibration"`
 }
 
 type UpdateProject struct {
-	Name     string              `json:"name" binding:"required"`
-	Info     *string             `json:"info"`
-	Interval *int                `json:"interval"`
-	Running  *bool               `json:"running"`
-	Camera   *bool               `json:"camera"`
-	Video    *types.VideoSetting `json:"video"`
+	Name     string                `json:"name" binding:"required"`
+	Info     *string               `json:"info"`
+	Interval *int                  `json:"interval"`
+	Running  *bool                 `json:"running"`
+	Camera   *bool                 `json:"camera"`
+	Video    *types.VideoSetting   `json:"video"`
+	Device   *string               `json:"device"`
+	Capture  *types.CaptureSetting `json:"capture"`
+	View     *types.ViewSetting    `json:"view"`
+	Location *types.Location       `json:"location"`
+	Overlay  *types.OverlaySetting `json:"overlay"`
+	Filter   *types.FilterSetting  `json:"filter"`
+	Growth   *types.GrowthSetting  `json:"growth"`
+	// Calibration 灰卡颜色校正
+	Calibration *types.CalibrationSetting `json:"calibration"`
 }
 
 type ProjectName struct {
@@ -70,6 +94,111 @@ type Project struct {
 	Time string `json:"time"`
 
 	ImageTotal int `json:"imageTotal"`
+	// Skipped 被过滤的照片数量，按原因统计
+	Skipped map[string]int `json:"skipped,omitempty"`
+}
+
+// GrowthPoint 生长时间序列中一张照片一个区域的测量结果
+type GrowthPoint struct {
+	Time   time.Time `json:"time"`
+	Image  string    `json:"image"`
+	Number int       `json:"number"`
+	growth.Result
+}
+
+// Rendition 照片缩略图参数，宽高都为 0 时返回原图
+type Rendition struct {
+	Width   int `form:"w" binding:"min=0"`
+	Height  int `form:"h" binding:"min=0"`
+	Quality int `form:"q" binding:"min=0,max=100"`
+}
+
+// Archive 照片打包下载参数
+type Archive struct {
+	types.ImageFilter
+	// PartSize 按大小(MB)将照片分为多个压缩包，Part 为从 0 开始的序号；为 0 时不分包
+	PartSize int `form:"partSize" binding:"min=0"`
+	Part     int `form:"part" binding:"min=0"`
+}
+
+// Export 联系表/动图导出请求
+type Export struct {
+	// Type 为 contact-sheet、gif、webp 或 video
+	Type string `json:"type" binding:"required,oneof=contact-sheet gif webp video"`
+	// 选取照片列表中 [Start, End) 范围内每 Every 张一张，End 为 0 时到最后一张
+	Start int `json:"start" binding:"min=0"`
+	End   int `json:"end" binding:"min=0"`
+	Every int `json:"every" binding:"min=0"`
+	// Width 联系表每格、动图或视频的宽度，视频为 0 时与照片相同
+	Width   int `json:"width" binding:"min=0,max=1920"`
+	Columns int `json:"columns" binding:"min=0,max=20"`
+	FPS     int `json:"fps" binding:"min=0,max=50"`
+	Quality int `json:"quality" binding:"min=0,max=100"`
+	// Deflicker 视频去闪烁，为空时使用项目的视频设置
+	Deflicker *types.DeflickerSetting `json:"deflicker"`
+	// Blend 视频中相邻照片之间插入的交叉淡化帧数
+	Blend int `json:"blend" binding:"min=0,max=30"`
+	// Ramps 视频变速区间，Start、End 与 Start、End 字段一样为照片列表中的位置
+	Ramps []types.SpeedRamp `json:"ramps" binding:"dive"`
+}
+
+// ConcatVideos 视频分段拼接请求
+type ConcatVideos struct {
+	// Videos 按顺序拼接的视频名，为空时拼接全部分段
+	Videos []string `json:"videos"`
+}
+
+// Job 后台任务提交请求
+type Job struct {
+	// Type 为 export、concat、growth 或 thumbnails
+	Type    string `json:"type" binding:"required,oneof=export concat growth thumbnails"`
+	Project string `json:"project" binding:"required"`
+	// Priority 越大越先执行
+	Priority int `json:"priority"`
+	// Params 任务参数，export 同 Export，concat 同 ConcatVideos
+	Params json.RawMessage `json:"params"`
+}
+
+// Preview 实时预览参数
+type Preview struct {
+	Camera string `form:"camera"`
+	// 预览分辨率，会对齐到设备支持的尺寸；为 0 时使用拍照分辨率的 1/4
+	Width  int `form:"width" binding:"min=0"`
+	Height int `form:"height" binding:"min=0"`
+	// FPS 最大帧率，为 0 时不限制
+	FPS float64 `form:"fps" binding:"min=0"`
+	// Quality 重新编码的 JPEG 质量(1-100)，为 0 时不重新编码
+	Quality int `form:"quality" binding:"min=0,max=100"`
+	// Adaptive 根据发送耗时自动降低帧率
+	Adaptive bool `form:"adaptive"`
+}
+
+// WebRTCPreview WebRTC 预览参数，Quality 与 Adaptive 不生效
+type WebRTCPreview struct {
+	Preview
+	Codec string `form:"codec" binding:"omitempty,oneof=vp8 h264"`
+	// Encoder auto 时 h264 优先使用硬件编码器
+	Encoder string `form:"encoder" binding:"omitempty,oneof=auto software hardware"`
+	// Bitrate kbps，为 0 时由编码器决定
+	Bitrate int `form:"bitrate" binding:"min=0"`
+}
+
+// Snapshot 单张快照请求，所有字段可选
+type Snapshot struct {
+	// Capture 拍照设置，分辨率为 0 时使用摄像头的拍照分辨率
+	Capture types.CaptureSetting `json:"capture"`
+	// Controls 仅本次拍摄使用的控制参数，拍完后恢复
+	Controls types.CameraSettings `json:"controls"`
+	// Save 为 true 时保存到 snapshots 目录并返回文件名，否则直接返回 JPEG
+	Save bool `json:"save"`
+}
+
+// TestShot 试拍请求，未指定的设置使用项目保存的值
+type TestShot struct {
+	Camera  *types.CameraSettings `json:"camera"`
+	Capture *types.CaptureSetting `json:"capture"`
+	// View 仅派生项目有效
+	View *types.ViewSetting `json:"view"`
 }
 
 type Time struct {
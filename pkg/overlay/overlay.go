@@ -0,0 +1,155 @@
+package overlay
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"plant-shutter-pi/pkg/imaging"
+	"plant-shutter-pi/pkg/types"
+)
+
+var (
+	lock  sync.Mutex
+	fnt   *opentype.Font
+	faces = make(map[int]font.Face)
+
+	// 文字背后的半透明底色，保证在亮暗画面上都清晰
+	background = color.NRGBA{A: 0x80}
+)
+
+// SetFont 使用 TTF/OTF 字体文件替换默认字体（Go Regular 不含中文字形）
+func SetFont(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	fnt = f
+	faces = make(map[int]font.Face)
+
+	return nil
+}
+
+func face(size int) (font.Face, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if f, ok := faces[size]; ok {
+		return f, nil
+	}
+	if fnt == nil {
+		f, err := opentype.Parse(goregular.TTF)
+		if err != nil {
+			return nil, err
+		}
+		fnt = f
+	}
+	f, err := opentype.NewFace(fnt, &opentype.FaceOptions{Size: float64(size), DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	faces[size] = f
+
+	return f, nil
+}
+
+// Apply 将 lines 按设置 s 绘制到 JPEG 照片上并重新编码
+func Apply(frame []byte, lines []string, s types.OverlaySetting) ([]byte, error) {
+	src, err := imaging.Decode(frame)
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewRGBA(src.Bounds())
+	draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+	if err = Draw(img, lines, s); err != nil {
+		return nil, err
+	}
+
+	return imaging.Encode(img, 0)
+}
+
+// Draw 将 lines 绘制到 img 上
+func Draw(img draw.Image, lines []string, s types.OverlaySetting) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	fg, err := ParseColor(s.Color)
+	if err != nil {
+		return err
+	}
+	b := img.Bounds()
+	size := s.FontSize
+	if size <= 0 {
+		size = max(12, b.Dy()/30)
+	}
+	f, err := face(size)
+	if err != nil {
+		return err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	m := f.Metrics()
+	lineH := (m.Ascent + m.Descent).Ceil()
+	pad := max(2, size/4)
+	width := 0
+	for _, l := range lines {
+		width = max(width, font.MeasureString(f, l).Ceil())
+	}
+	boxW, boxH := width+pad*2, lineH*len(lines)+pad*2
+
+	x, y := b.Min.X+pad, b.Min.Y+pad
+	if strings.HasSuffix(s.Position, "right") {
+		x = b.Max.X - pad - boxW
+	}
+	if s.Position == "" || strings.HasPrefix(s.Position, "bottom") {
+		y = b.Max.Y - pad - boxH
+	}
+	box := image.Rect(x, y, x+boxW, y+boxH).Intersect(b)
+	draw.Draw(img, box, image.NewUniform(background), image.Point{}, draw.Over)
+
+	d := &font.Drawer{Dst: img, Src: image.NewUniform(fg), Face: f}
+	for i, l := range lines {
+		d.Dot = fixed.P(x+pad, y+pad+lineH*i+m.Ascent.Ceil())
+		d.DrawString(l)
+	}
+
+	return nil
+}
+
+// ParseColor 解析 #RRGGBB 或 #RRGGBBAA，为空时返回白色
+func ParseColor(s string) (color.NRGBA, error) {
+	if s == "" {
+		return color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, nil
+	}
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("invalid color %s", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, errors.Join(fmt.Errorf("invalid color %s", s), err)
+	}
+
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
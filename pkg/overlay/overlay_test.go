@@ -0,0 +1,49 @@
+package overlay
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"plant-shutter-pi/pkg/types"
+)
+
+func TestDraw(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 100))
+	err := Draw(img, []string{"2024-05-01 08:30", "Day 3"}, types.OverlaySetting{
+		Position: "top-right",
+		FontSize: 12,
+		Color:    "#ff0000",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left, right int
+	for y := 0; y < 50; y++ {
+		for x := 0; x < 400; x++ {
+			if img.RGBAAt(x, y).R > 0 {
+				if x < 200 {
+					left++
+				} else {
+					right++
+				}
+			}
+		}
+	}
+	if right == 0 || left != 0 {
+		t.Fatalf("text should be drawn on the right only, got left=%d right=%d", left, right)
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	c, err := ParseColor("#10203040")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != (color.NRGBA{R: 0x10, G: 0x20, B: 0x30, A: 0x40}) {
+		t.Fatalf("got %v", c)
+	}
+	if _, err = ParseColor("red"); err == nil {
+		t.Fatal("expected error")
+	}
+}
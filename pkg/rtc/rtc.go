@@ -0,0 +1,394 @@
+package rtc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/pion/webrtc/v4/pkg/media/h264reader"
+	"github.com/pion/webrtc/v4/pkg/media/ivfreader"
+	"go.uber.org/zap"
+
+	"plant-shutter-pi/pkg/camera"
+	"plant-shutter-pi/pkg/utils"
+)
+
+const (
+	CodecVP8  = "vp8"
+	CodecH264 = "h264"
+
+	EncoderAuto     = "auto"
+	EncoderSoftware = "software"
+	EncoderHardware = "hardware"
+
+	defaultFPS = 15
+	// 连接建立的最长等待时间
+	connectTimeout = 30 * time.Second
+	gatherTimeout  = 5 * time.Second
+)
+
+var logger *zap.SugaredLogger
+
+func init() {
+	logger = utils.GetLogger()
+}
+
+// Options 为一路 WebRTC 预览的编码设置
+type Options struct {
+	// Codec vp8 或 h264
+	Codec string
+	// Encoder auto 时若存在硬件编码器且编码为 h264 则使用硬件编码
+	Encoder string
+	// Width 输出宽度，为 0 时与预览相同，高度按比例计算
+	Width int
+	FPS   int
+	// Bitrate kbps，为 0 时由编码器决定
+	Bitrate int
+}
+
+// Server 负责 WebRTC 信令应答。
+// 不配置 STUN/TURN，只使用本机地址作为候选，适用于局域网。
+type Server struct {
+	api *webrtc.API
+
+	lock     sync.Mutex
+	sessions map[*session]struct{}
+}
+
+func NewServer() *Server {
+	return &Server{
+		api:      webrtc.NewAPI(),
+		sessions: make(map[*session]struct{}),
+	}
+}
+
+// Sessions 返回当前的 WebRTC 连接数
+func (s *Server) Sessions() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.sessions)
+}
+
+// Answer 处理浏览器的 offer 并返回 answer，之后将 viewer 的预览帧编码后推送给浏览器。
+// 调用成功后 viewer 由会话负责关闭。
+func (s *Server) Answer(offer webrtc.SessionDescription, viewer *camera.Viewer, opts Options) (*webrtc.SessionDescription, error) {
+	args, mimeType, err := encoderArgs(opts)
+	if err != nil {
+		return nil, err
+	}
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("webrtc preview needs ffmpeg: %w", err)
+	}
+
+	pc, err := s.api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return nil, err
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: mimeType}, "video", "plant-shutter")
+	if err != nil {
+		_ = pc.Close()
+		return nil, err
+	}
+	sender, err := pc.AddTrack(track)
+	if err != nil {
+		_ = pc.Close()
+		return nil, err
+	}
+	// 读取 RTCP，否则拥塞控制等拦截器无法工作
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := sender.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err = pc.SetRemoteDescription(offer); err != nil {
+		_ = pc.Close()
+		return nil, err
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		_ = pc.Close()
+		return nil, err
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err = pc.SetLocalDescription(answer); err != nil {
+		_ = pc.Close()
+		return nil, err
+	}
+	// 不使用 trickle ICE，等候选收集完成后一次性返回
+	select {
+	case <-gathered:
+	case <-time.After(gatherTimeout):
+		logger.Warn("webrtc: ice gathering timeout")
+	}
+
+	ss := &session{
+		server: s,
+		pc:     pc,
+		viewer: viewer,
+		track:  track,
+		cmd:    exec.Command(ffmpeg, args...),
+		codec:  opts.Codec,
+		fps:    opts.FPS,
+		done:   make(chan struct{}),
+	}
+	s.lock.Lock()
+	s.sessions[ss] = struct{}{}
+	s.lock.Unlock()
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		logger.Infof("webrtc: connection state %s", state)
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			go ss.start()
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateDisconnected, webrtc.PeerConnectionStateClosed:
+			go ss.close()
+		}
+	})
+	time.AfterFunc(connectTimeout, func() {
+		if pc.ConnectionState() != webrtc.PeerConnectionStateConnected {
+			ss.close()
+		}
+	})
+
+	return pc.LocalDescription(), nil
+}
+
+// encoderArgs 返回 ffmpeg 参数：从标准输入读取 MJPEG，向标准输出写出 IVF(VP8) 或 H.264 码流
+func encoderArgs(opts Options) ([]string, string, error) {
+	fps := opts.FPS
+	if fps <= 0 {
+		fps = defaultFPS
+	}
+	gop := strconv.Itoa(fps * 2)
+	args := []string{
+		"-loglevel", "error",
+		"-fflags", "nobuffer",
+		"-f", "mjpeg", "-framerate", strconv.Itoa(fps), "-i", "-",
+		"-an",
+	}
+	// 编码器要求宽高为偶数
+	if opts.Width > 0 {
+		args = append(args, "-vf", fmt.Sprintf("scale=%d:-2", opts.Width&^1))
+	} else {
+		args = append(args, "-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2")
+	}
+	if opts.Bitrate > 0 {
+		args = append(args, "-b:v", fmt.Sprintf("%dk", opts.Bitrate))
+	}
+
+	switch opts.Codec {
+	case CodecVP8, "":
+		if opts.Encoder == EncoderHardware {
+			return nil, "", errors.New("no hardware encoder for vp8, use h264")
+		}
+		args = append(args,
+			"-c:v", "libvpx", "-deadline", "realtime", "-cpu-used", "8", "-g", gop,
+			"-f", "ivf", "-",
+		)
+		return args, webrtc.MimeTypeVP8, nil
+	case CodecH264:
+		hw := opts.Encoder == EncoderHardware
+		if opts.Encoder == EncoderAuto || opts.Encoder == "" {
+			hw = camera.FindEncoder() != ""
+		}
+		if hw {
+			// 由 ffmpeg 通过 V4L2 M2M 接口调用硬件编码器
+			args = append(args, "-c:v", "h264_v4l2m2m", "-pix_fmt", "yuv420p", "-g", gop)
+		} else {
+			args = append(args,
+				"-c:v", "libx264", "-preset", "ultrafast", "-tune", "zerolatency",
+				"-profile:v", "baseline", "-pix_fmt", "yuv420p", "-g", gop,
+			)
+		}
+		args = append(args, "-f", "h264", "-")
+		return args, webrtc.MimeTypeH264, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported codec %s", opts.Codec)
+	}
+}
+
+type session struct {
+	server *Server
+	pc     *webrtc.PeerConnection
+	viewer *camera.Viewer
+	track  *webrtc.TrackLocalStaticSample
+	cmd    *exec.Cmd
+	codec  string
+	fps    int
+
+	lock    sync.Mutex
+	started bool
+	closed  bool
+	done    chan struct{}
+}
+
+// start 启动编码进程，连接建立后调用
+func (s *session) start() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.started || s.closed {
+		return
+	}
+	s.started = true
+	stdin, err := s.cmd.StdinPipe()
+	if err != nil {
+		logger.Errorf("webrtc: %s", err)
+		go s.close()
+		return
+	}
+	stdout, err := s.cmd.StdoutPipe()
+	if err != nil {
+		logger.Errorf("webrtc: %s", err)
+		go s.close()
+		return
+	}
+	if err = s.cmd.Start(); err != nil {
+		logger.Errorf("webrtc: start encoder err: %s", err)
+		go s.close()
+		return
+	}
+	go s.feed(stdin)
+	go func() {
+		var err error
+		if s.codec == CodecH264 {
+			err = s.sendH264(bufio.NewReader(stdout))
+		} else {
+			err = s.sendVP8(stdout)
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			logger.Warnf("webrtc: send video err: %s", err)
+		}
+		s.close()
+	}()
+}
+
+// feed 将预览帧写入编码器，超过帧率的帧被丢弃
+func (s *session) feed(stdin io.WriteCloser) {
+	defer stdin.Close()
+	fps := s.fps
+	if fps <= 0 {
+		fps = defaultFPS
+	}
+	interval := time.Second / time.Duration(fps)
+	var last time.Time
+	for {
+		select {
+		case <-s.done:
+			return
+		case frame, ok := <-s.viewer.Frames():
+			if !ok {
+				return
+			}
+			now := time.Now()
+			if now.Sub(last) < interval {
+				continue
+			}
+			last = now
+			if _, err := stdin.Write(frame); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *session) sendVP8(r io.Reader) error {
+	ivf, _, err := ivfreader.NewWith(r)
+	if err != nil {
+		return err
+	}
+	last := time.Now()
+	for {
+		frame, _, err := ivf.ParseNextFrame()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if err = s.track.WriteSample(media.Sample{Data: frame, Duration: now.Sub(last)}); err != nil {
+			return err
+		}
+		last = now
+	}
+}
+
+// sendH264 将 NAL 单元按访问单元（一帧）组合后发送。x264 的 zerolatency 使用多线程切片，
+// 一帧可能包含多个切片，因此在下一帧开始（AUD、参数集或 first_mb_in_slice 为 0 的切片）时才发送上一帧。
+func (s *session) sendH264(r io.Reader) error {
+	h264, err := h264reader.NewReader(r)
+	if err != nil {
+		return err
+	}
+	last := time.Now()
+	var au []byte
+	hasSlice := false
+	for {
+		nal, err := h264.NextNAL()
+		if err != nil {
+			return err
+		}
+		if hasSlice && startsAccessUnit(nal) {
+			now := time.Now()
+			if err = s.track.WriteSample(media.Sample{Data: au, Duration: now.Sub(last)}); err != nil {
+				return err
+			}
+			last = now
+			au, hasSlice = nil, false
+		}
+		au = append(au, 0, 0, 0, 1)
+		au = append(au, nal.Data...)
+		if isSlice(nal) {
+			hasSlice = true
+		}
+	}
+}
+
+func isSlice(nal *h264reader.NAL) bool {
+	return nal.UnitType == h264reader.NalUnitTypeCodedSliceIdr || nal.UnitType == h264reader.NalUnitTypeCodedSliceNonIdr
+}
+
+// startsAccessUnit 判断 nal 是否为新一帧的开始
+func startsAccessUnit(nal *h264reader.NAL) bool {
+	switch nal.UnitType {
+	case h264reader.NalUnitTypeAUD, h264reader.NalUnitTypeSEI, h264reader.NalUnitTypeSPS, h264reader.NalUnitTypePPS:
+		return true
+	case h264reader.NalUnitTypeCodedSliceIdr, h264reader.NalUnitTypeCodedSliceNonIdr:
+		// first_mb_in_slice 为 ue(v) 编码，值为 0 时第一个比特为 1
+		return len(nal.Data) > 1 && nal.Data[1]&0x80 != 0
+	}
+
+	return false
+}
+
+func (s *session) close() {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.done)
+	started := s.started && s.cmd.Process != nil
+	s.lock.Unlock()
+
+	_ = s.pc.Close()
+	s.viewer.Close()
+	if started {
+		_ = s.cmd.Process.Kill()
+		_ = s.cmd.Wait()
+	}
+	s.server.lock.Lock()
+	delete(s.server.sessions, s)
+	s.server.lock.Unlock()
+}
@@ -0,0 +1,83 @@
+package schedule
+
+import (
+	"context"
+	"sync"
+
+	"plant-shutter-pi/pkg/camera"
+	"plant-shutter-pi/pkg/storage/project"
+)
+
+// Background 与拍摄共享 CPU 的后台任务，拍摄期间暂停
+type Background interface {
+	Pause()
+	Resume()
+}
+
+// Manager 为每个摄像头维护一个调度器，不同摄像头上的项目可同时运行
+type Manager struct {
+	ctx context.Context
+	bg  Background
+
+	lock       sync.Mutex
+	schedulers map[*camera.Unit]*Scheduler
+}
+
+// NewManager bg 可为 nil
+func NewManager(ctx context.Context, bg Background) *Manager {
+	return &Manager{
+		ctx:        ctx,
+		bg:         bg,
+		schedulers: make(map[*camera.Unit]*Scheduler),
+	}
+}
+
+// Get 返回摄像头对应的调度器，不存在时创建
+func (m *Manager) Get(unit *camera.Unit) *Scheduler {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	s, ok := m.schedulers[unit]
+	if !ok {
+		s = New(m.ctx, unit, m.bg)
+		m.schedulers[unit] = s
+	}
+
+	return s
+}
+
+// Running 返回所有正在运行的项目
+func (m *Manager) Running() []*project.Project {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var res []*project.Project
+	for _, s := range m.schedulers {
+		if p := s.GetProject(); p != nil {
+			res = append(res, p)
+		}
+	}
+
+	return res
+}
+
+// Find 返回正在运行名为 name 的项目的调度器，未运行时返回 nil
+func (m *Manager) Find(name string) *Scheduler {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, s := range m.schedulers {
+		if p := s.GetProject(); p != nil && p.Name == name {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// Stop 若名为 name 的项目正在运行则停止
+func (m *Manager) Stop(name string) {
+	if s := m.Find(name); s != nil {
+		s.Stop()
+	}
+}
@@ -7,6 +7,8 @@ import (
 
 	"go.uber.org/zap"
 	"plant-shutter-pi/pkg/camera"
+	"plant-shutter-pi/pkg/exif"
+	"plant-shutter-pi/pkg/imaging"
 	"plant-shutter-pi/pkg/storage/consts"
 
 	"plant-shutter-pi/pkg/storage/project"
@@ -15,34 +17,49 @@ import (
 
 type Scheduler struct {
 	t          *time.Ticker
+	unit       *camera.Unit
 	controller *camera.Controller
+	supervisor *camera.Supervisor
 	p          *project.Project
-	lock       sync.Mutex
-	logger     *zap.SugaredLogger
+	// views 正在运行项目的派生项目，使用同一张照片生成
+	views  []*project.Project
+	lock   sync.Mutex
+	logger *zap.SugaredLogger
+	// bg 拍摄期间暂停的后台任务
+	bg Background
 }
 
-func New(ctx context.Context, controller *camera.Controller) *Scheduler {
+// New 创建绑定到一个摄像头的调度器，每个摄像头同时只运行一个项目
+func New(ctx context.Context, unit *camera.Unit, bg Background) *Scheduler {
 	t := time.NewTicker(time.Second)
 	t.Stop()
 
 	s := &Scheduler{
 		t:          t,
-		controller: controller,
+		unit:       unit,
+		controller: unit.Controller,
+		supervisor: unit.Supervisor,
 		logger:     utils.GetLogger(),
+		bg:         bg,
 	}
 	s.startDeal(ctx)
 
 	return s
 }
 
-func (s *Scheduler) Begin(p *project.Project) {
+// Begin 开始运行项目 p，views 为其派生项目
+func (s *Scheduler) Begin(p *project.Project, views []*project.Project) {
 	if p == nil {
 		s.Stop()
 	}
 	s.lock.Lock()
+	s.closeProject()
 	s.p = p
+	s.closeViews()
+	s.views = views
 	s.lock.Unlock()
 	if p != nil {
+		s.keepStreaming(p)
 		s.t.Reset(utils.MsToDuration(p.Interval))
 	}
 }
@@ -51,8 +68,90 @@ func (s *Scheduler) Stop() {
 	s.logger.Info("scheduler: stopped")
 	s.t.Stop()
 	s.lock.Lock()
+	s.closeProject()
 	s.p = nil
+	s.closeViews()
 	s.lock.Unlock()
+	s.keepStreaming(nil)
+}
+
+// AddView 为正在运行的项目添加派生项目，从下一张照片开始生效
+func (s *Scheduler) AddView(v *project.Project) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.views = append(s.views, v)
+}
+
+// RemoveView 移除派生项目并关闭其视频
+func (s *Scheduler) RemoveView(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, v := range s.views {
+		if v.Name == name {
+			_ = v.Close()
+			s.views = append(s.views[:i], s.views[i+1:]...)
+			return
+		}
+	}
+}
+
+// closeProject 结束正在运行项目的视频分段，重新开始时按项目设置继续写入或开始新分段
+func (s *Scheduler) closeProject() {
+	if s.p == nil {
+		return
+	}
+	if err := s.p.Close(); err != nil {
+		s.logger.Warnf("scheduler: close project %s err: %s", s.p.Name, err)
+	}
+}
+
+func (s *Scheduler) closeViews() {
+	for _, v := range s.views {
+		if err := v.Close(); err != nil {
+			s.logger.Warnf("scheduler: close view %s err: %s", v.Name, err)
+		}
+	}
+	s.views = nil
+}
+
+// saveViews 由一张照片生成所有派生项目的照片，只解码一次
+func (s *Scheduler) saveViews(frame []byte, meta exif.Metadata) {
+	if len(s.views) == 0 {
+		return
+	}
+	img, err := imaging.Decode(frame)
+	if err != nil {
+		s.logger.Errorf("scheduler: decode image for views err: %s", err)
+		return
+	}
+	for _, v := range s.views {
+		if err = v.SaveView(img, meta); err != nil {
+			s.logger.Errorf("scheduler: save view %s err: %s", v.Name, err)
+		}
+	}
+}
+
+// keepStreaming 短间隔时保持相机常开，避免每次拍照重新打开设备；p 为 nil 时关闭
+func (s *Scheduler) keepStreaming(p *project.Project) {
+	if p == nil || p.Interval > consts.StreamInterval {
+		if err := s.controller.StopStream(); err != nil {
+			s.logger.Warnf("scheduler: stop camera stream err: %s", err)
+		}
+		return
+	}
+	if err := s.controller.StartStream(s.unit.CaptureSetting(p.Capture)); err != nil {
+		s.logger.Warnf("scheduler: keep camera streaming err: %s", err)
+	}
+}
+
+// Exclusive 在调度锁内执行 fn，期间不会进行定时拍摄
+func (s *Scheduler) Exclusive(fn func() error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return fn()
 }
 
 func (s *Scheduler) GetProject() *project.Project {
@@ -72,25 +171,41 @@ func (s *Scheduler) startDeal(ctx context.Context) {
 				s.logger.Debugf("scheduler: starting deal: %v", start)
 				if s.p == nil {
 					s.logger.Warn("scheduler: should close when the project is nil!")
+					s.lock.Unlock()
 					continue
 				}
-				frame, err := s.controller.Capture(consts.Width, consts.Height)
+				if st := s.supervisor.Status(); st.State != camera.StateConnected {
+					s.logger.Warnf("scheduler: skip capture, camera is %s: %s", st.State, st.LastError)
+					s.lock.Unlock()
+					continue
+				}
+				// 拍摄与保存优先于后台任务
+				if s.bg != nil {
+					s.bg.Pause()
+				}
+				frame, err := s.controller.Capture(s.unit.CaptureSetting(s.p.Capture))
 				if err != nil {
 					s.logger.Errorf("get frame error: %s", err)
 				} else {
-					if err = s.p.SaveImage(frame); err != nil {
+					meta := s.unit.Metadata()
+					meta.Time = start
+					if err = s.p.SaveImage(frame, meta); err != nil {
 						s.logger.Errorf("scheduler: save image err: %s", err)
 					}
+					s.saveViews(frame, meta)
+				}
+				if s.bg != nil {
+					s.bg.Resume()
 				}
 
 				s.lock.Unlock()
 				s.logger.Infof("scheduler: took %s to get the image", time.Now().Sub(start))
 			case <-ctx.Done():
 				s.lock.Lock()
-				if s.p != nil {
-					_ = s.p.Close()
-				}
+				s.closeProject()
+				s.closeViews()
 				s.lock.Unlock()
+				s.keepStreaming(nil)
 				s.logger.Info("scheduler: stopped!")
 				return
 			}
@@ -3,8 +3,14 @@ package consts
 const (
 	DefaultImagesDir       = "images"
 	DefaultVideosDir       = "videos"
+	DefaultSnapshotsDir    = "snapshots"
+	DefaultThumbsDir       = "thumbs"
+	DefaultExportsDir      = "exports"
+	DefaultLowDir          = "low"
 	DefaultInfoFile        = "info.json"
+	DefaultIndexFile       = "index.jsonl"
 	DefaultLastRunningFile = "last.json"
+	DefaultJobsFile        = "jobs.json"
 
 	DefaultImageExt = ".jpg"
 	DefaultVideoExt = ".avi"
@@ -15,9 +21,7 @@ const (
 	MinInterval = 300
 	Width1080P  = 1920
 	Height1080P = 1080
-)
 
-var (
-	Width  int
-	Height int
+	// StreamInterval 拍摄间隔不超过该值(ms)时保持相机常开
+	StreamInterval = 3000
 )
@@ -0,0 +1,49 @@
+package project
+
+import (
+	"image"
+
+	"plant-shutter-pi/pkg/calibrate"
+	"plant-shutter-pi/pkg/imaging"
+)
+
+// CalibrationRecord 为一张照片的颜色校正参数
+type CalibrationRecord struct {
+	// Patch 灰卡区域测得的平均 RGB
+	Patch [3]float64      `json:"patch"`
+	Gains calibrate.Gains `json:"gains"`
+}
+
+// calibrateImage 按灰卡校正照片，返回校正后的照片与对应的解码函数；未开启或失败时原样返回
+func (p *Project) calibrateImage(data []byte, decode func() (image.Image, error), rec *ImageRecord) ([]byte, func() (image.Image, error)) {
+	if !p.Calibration.Enable {
+		return data, decode
+	}
+	img, err := decode()
+	if err != nil {
+		logger.Warnf("decode image for calibration err: %s", err)
+		return data, decode
+	}
+	mean, err := calibrate.Measure(img, p.Calibration.Patch)
+	if err != nil {
+		logger.Warnf("%s: %s", p.Name, err)
+		return data, decode
+	}
+	gains := calibrate.Smooth(p.calibration, calibrate.Compute(mean, p.Calibration.Target), p.Calibration.Smoothing)
+	out := calibrate.Apply(img, gains)
+	quality := p.Capture.Quality
+	if p.View != nil {
+		quality = p.View.Quality
+	}
+	res, err := imaging.Encode(out, quality)
+	if err != nil {
+		logger.Warnf("encode calibrated image err: %s", err)
+		return data, decode
+	}
+	// 平滑用的增益在照片通过过滤后由 SaveImage 更新，被丢弃的照片不参与平滑
+	rec.Calibration = &CalibrationRecord{Patch: mean, Gains: gains}
+
+	return res, func() (image.Image, error) {
+		return out, nil
+	}
+}
@@ -0,0 +1,89 @@
+package project
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path"
+
+	"plant-shutter-pi/pkg/filter"
+	"plant-shutter-pi/pkg/storage/consts"
+	"plant-shutter-pi/pkg/types"
+	"plant-shutter-pi/pkg/utils"
+)
+
+// filterState 记录上一张保存的照片的亮度图与之后被过滤的数量，只保存在内存中
+type filterState struct {
+	last    *filter.Signature
+	skipped map[string]int
+}
+
+// checkFilter 判断照片是否应被过滤，返回过滤原因，为空时保留；亮度与变化量记录到 rec
+func (p *Project) checkFilter(decode func() (image.Image, error), rec *ImageRecord) string {
+	if !p.Filter.Enable {
+		return ""
+	}
+	img, err := decode()
+	if err != nil {
+		logger.Warnf("decode image for filter err: %s", err)
+		return ""
+	}
+	sig := filter.FromImage(img)
+	if p.filter.last == nil {
+		// 重启后与最后保存的照片比较
+		if latest, err := p.LatestImage(); err == nil && len(latest) > 0 {
+			p.filter.last, _ = filter.Compute(latest)
+		}
+	}
+	reason, change := filter.Check(p.Filter, p.filter.last, sig)
+	rec.Brightness = sig.Brightness
+	rec.Change = change
+	if reason != "" && p.Filter.MaxSkip > 0 && p.skippedCount() >= p.Filter.MaxSkip {
+		reason = ""
+	}
+	if reason == "" {
+		p.filter.last = sig
+		rec.Skipped = p.filter.skipped
+		p.filter.skipped = nil
+	}
+
+	return reason
+}
+
+// saveFiltered 记录被过滤的照片，Action 为 low 时保存到 low 目录
+func (p *Project) saveFiltered(image []byte, rec *ImageRecord, reason string, info *ImagesInfo) error {
+	if p.filter.skipped == nil {
+		p.filter.skipped = make(map[string]int)
+	}
+	p.filter.skipped[reason]++
+	if info.Skipped == nil {
+		info.Skipped = make(map[string]int)
+	}
+	info.Skipped[reason]++
+	if err := p.dumpImageInfo(info, false); err != nil {
+		return err
+	}
+	logger.Debugf("%s: image filtered: %s, brightness %.1f, change %.1f", p.Name, reason, rec.Brightness, rec.Change)
+	if p.Filter.Action != types.FilterLow {
+		return nil
+	}
+	if err := utils.MkdirAll(p.getLowDirPath()); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s-%s%s", p.Name, rec.Time.Format("20060102-150405"), reason, consts.DefaultImageExt)
+
+	return os.WriteFile(path.Join(p.getLowDirPath(), name), image, consts.DefaultFilePerm)
+}
+
+func (p *Project) skippedCount() int {
+	n := 0
+	for _, v := range p.filter.skipped {
+		n += v
+	}
+
+	return n
+}
+
+func (p *Project) getLowDirPath() string {
+	return path.Join(p.rootDir, consts.DefaultLowDir)
+}
@@ -0,0 +1,88 @@
+package project
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io/fs"
+	"strings"
+	"sync"
+
+	"plant-shutter-pi/pkg/growth"
+	"plant-shutter-pi/pkg/imaging"
+)
+
+// decoder 返回只解码一次的函数，供过滤与生长分析共用
+func decoder(data []byte) func() (image.Image, error) {
+	return sync.OnceValues(func() (image.Image, error) {
+		return imaging.Decode(data)
+	})
+}
+
+func (p *Project) analyzeGrowth(decode func() (image.Image, error), rec *ImageRecord) {
+	if !p.Growth.Enable {
+		return
+	}
+	img, err := decode()
+	if err != nil {
+		logger.Warnf("decode image for growth err: %s", err)
+		return
+	}
+	rec.Growth = growth.Analyze(img, p.Growth)
+}
+
+// AnalyzeGrowth 按当前设置重新分析所有照片并更新照片索引，已删除照片的记录会被移除
+func (p *Project) AnalyzeGrowth(ctx context.Context, progress func(float64)) error {
+	records := make(map[string]*ImageRecord)
+	err := p.LoadIndex(func(r *ImageRecord) error {
+		records[r.Name] = r
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var images []fs.FileInfo
+	err = p.ListImages(func(info fs.FileInfo) error {
+		images = append(images, info)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	res := make([]*ImageRecord, 0, len(images))
+	for i, info := range images {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		name := info.Name()
+		r, ok := records[name]
+		if !ok {
+			r = &ImageRecord{Name: name, Number: p.imageNumber(name), Time: info.ModTime()}
+		}
+		data, err := p.GetImage(name)
+		if err != nil {
+			return err
+		}
+		if img, err := imaging.Decode(data); err != nil {
+			logger.Warnf("decode %s for growth err: %s", name, err)
+			r.Growth = nil
+		} else {
+			r.Growth = growth.Analyze(img, p.Growth)
+		}
+		res = append(res, r)
+		if progress != nil {
+			progress(float64(i+1) / float64(len(images)))
+		}
+	}
+
+	return p.rewriteIndex(res)
+}
+
+// imageNumber 从照片名称中解析序号
+func (p *Project) imageNumber(name string) int {
+	n := 0
+	_, _ = fmt.Sscanf(strings.TrimPrefix(name, p.Name+"-"), "%d", &n)
+
+	return n
+}
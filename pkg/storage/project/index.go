@@ -0,0 +1,136 @@
+package project
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"plant-shutter-pi/pkg/growth"
+	"plant-shutter-pi/pkg/storage/consts"
+)
+
+// indexLock 保护照片索引的追加与重写，调度器与后台任务持有不同的 Project 对象
+var indexLock sync.Mutex
+
+// ImageRecord 为照片索引中的一条记录，每保存一张照片追加一行
+type ImageRecord struct {
+	Name   string    `json:"name"`
+	Number int       `json:"number"`
+	Time   time.Time `json:"time"`
+	// Brightness 平均亮度 0-255，Change 与上一张保存的照片的平均亮度差，仅在开启过滤时记录
+	Brightness float64 `json:"brightness,omitempty"`
+	Change     float64 `json:"change,omitempty"`
+	// Skipped 与上一张保存的照片之间被过滤的数量，按原因统计
+	Skipped map[string]int `json:"skipped,omitempty"`
+	// Growth 各分析区域的生长测量结果
+	Growth []growth.Result `json:"growth,omitempty"`
+	// Calibration 颜色校正参数
+	Calibration *CalibrationRecord `json:"calibration,omitempty"`
+	// Video 照片写入的视频分段
+	Video string `json:"video,omitempty"`
+}
+
+// LoadIndex 按保存顺序读取照片索引
+func (p *Project) LoadIndex(fun func(r *ImageRecord) error) error {
+	f, err := os.Open(p.getIndexPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r ImageRecord
+		if err = json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// 断电可能留下不完整的最后一行
+			logger.Warnf("skip broken index line of %s: %s", p.Name, err)
+			continue
+		}
+		if err = fun(&r); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (p *Project) appendIndex(r *ImageRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	indexLock.Lock()
+	defer indexLock.Unlock()
+	f, err := os.OpenFile(p.getIndexPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, consts.DefaultFilePerm)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// rewriteIndex 用 records 替换照片索引，期间新追加的记录保留在末尾
+func (p *Project) rewriteIndex(records []*ImageRecord) error {
+	indexLock.Lock()
+	defer indexLock.Unlock()
+
+	names := make(map[string]struct{}, len(records))
+	for _, r := range records {
+		names[r.Name] = struct{}{}
+	}
+	last := ""
+	if len(records) > 0 {
+		last = records[len(records)-1].Name
+	}
+	err := p.LoadIndex(func(r *ImageRecord) error {
+		if _, ok := names[r.Name]; !ok && r.Name > last {
+			records = append(records, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp := p.getIndexPath() + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err = enc.Encode(r); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, p.getIndexPath())
+}
+
+func (p *Project) getIndexPath() string {
+	return path.Join(p.rootDir, consts.DefaultImagesDir, consts.DefaultIndexFile)
+}
@@ -0,0 +1,54 @@
+package project
+
+import (
+	"fmt"
+	"strings"
+
+	"plant-shutter-pi/pkg/exif"
+	"plant-shutter-pi/pkg/overlay"
+	"plant-shutter-pi/pkg/types"
+)
+
+// applyOverlay 按叠加设置返回要保存的照片与要写入视频的帧
+func (p *Project) applyOverlay(image []byte, meta exif.Metadata, info *ImagesInfo) (saved, frame []byte) {
+	if !p.Overlay.Enable {
+		return image, nil
+	}
+	lines := p.overlayLines(meta, info)
+	data, err := overlay.Apply(image, lines, p.Overlay)
+	if err != nil {
+		logger.Warnf("draw overlay err: %s", err)
+		return image, nil
+	}
+	switch p.Overlay.Target {
+	case types.OverlayImages:
+		return data, image
+	case types.OverlayBoth:
+		return data, nil
+	default:
+		// 仅视频：保存的照片保持原样
+		return image, data
+	}
+}
+
+func (p *Project) overlayLines(meta exif.Metadata, info *ImagesInfo) []string {
+	var lines []string
+	if p.Overlay.Time {
+		lines = append(lines, meta.Time.Format("2006-01-02 15:04:05"))
+	}
+	if p.Overlay.Day {
+		start := meta.Time
+		if info.StartedAt != nil {
+			start = *info.StartedAt
+		}
+		lines = append(lines, fmt.Sprintf("Day %d", int(meta.Time.Sub(start).Hours()/24)+1))
+	}
+	if p.Overlay.Name {
+		lines = append(lines, p.Name)
+	}
+	if p.Overlay.Text != "" {
+		lines = append(lines, strings.Split(p.Overlay.Text, "\n")...)
+	}
+
+	return lines
+}
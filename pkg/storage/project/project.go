@@ -1,16 +1,20 @@
 package project
 
 import (
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/goccy/go-json"
 	"go.uber.org/zap"
 
+	"plant-shutter-pi/pkg/calibrate"
+	"plant-shutter-pi/pkg/exif"
 	"plant-shutter-pi/pkg/storage/consts"
 	"plant-shutter-pi/pkg/types"
 	"plant-shutter-pi/pkg/utils"
@@ -32,11 +36,33 @@ type Project struct {
 	Interval int                  `json:"interval"`
 	Camera   types.CameraSettings `json:"camera"`
 	Video    types.VideoSetting   `json:"video"`
+	// Device 拍摄所用摄像头的稳定 ID，为空时使用默认摄像头
+	Device  string               `json:"device"`
+	Capture types.CaptureSetting `json:"capture"`
+	// Parent 不为空时为派生项目，不单独拍摄，照片由父项目的照片裁剪得到
+	Parent string             `json:"parent,omitempty"`
+	View   *types.ViewSetting `json:"view,omitempty"`
+	// Location 拍摄地点，写入照片的 EXIF
+	Location *types.Location `json:"location,omitempty"`
+	// Overlay 叠加到照片或视频上的文字
+	Overlay types.OverlaySetting `json:"overlay"`
+	// Filter 过滤没有变化或过暗的照片
+	Filter types.FilterSetting `json:"filter"`
+	// Growth 保存照片时测量植物生长，结果写入照片索引
+	Growth types.GrowthSetting `json:"growth"`
+	// Calibration 保存前用灰卡校正颜色
+	Calibration types.CalibrationSetting `json:"calibration"`
 
 	CreatedAt time.Time `json:"createdAt"`
 
-	video   *video.Builder
-	rootDir string
+	video       *video.Builder
+	filter      filterState
+	calibration *calibrate.Gains
+	// deflicker 在视频分段之间保留亮度窗口，deflickerSetting 与 deflickerQuality 为创建时的设置
+	deflicker        *video.Deflicker
+	deflickerSetting types.DeflickerSetting
+	deflickerQuality int
+	rootDir          string
 }
 
 type ImagesInfo struct {
@@ -45,12 +71,21 @@ type ImagesInfo struct {
 
 	StartedAt *time.Time `json:"startedAt"`
 	EndedAt   *time.Time `json:"endedAt"`
+	// Skipped 被过滤的照片数量，按原因统计
+	Skipped map[string]int `json:"skipped,omitempty"`
 
 	UpdateAt *time.Time `json:"updateAt"`
 }
 
 type VideoInfo struct {
 	MaxNumber int `json:"maxNumber"`
+	// Current 最近写入的视频分段，Frames 为其中的帧数
+	Current string `json:"current,omitempty"`
+	Frames  int    `json:"frames"`
+	// FullFrames 最近的分段中已追加到完整视频的帧数
+	FullFrames int `json:"fullFrames"`
+	// FullPart 正在追加的完整视频编号，达到 AVI 大小上限后从 2 开始递增
+	FullPart int `json:"fullPart,omitempty"`
 
 	UpdateAt *time.Time `json:"updateAt"`
 }
@@ -59,15 +94,9 @@ func (p *Project) SetRootDir(dir string) {
 	p.rootDir = path.Join(dir, p.Name)
 }
 
-func New(name, info string, interval int, rootDir string, camera types.CameraSettings, video types.VideoSetting) (*Project, error) {
-	p := &Project{
-		Name:      name,
-		Info:      info,
-		Interval:  interval,
-		Camera:    camera,
-		Video:     video,
-		CreatedAt: time.Now(),
-	}
+// New 按 p 中的设置创建项目并初始化存储目录
+func New(p *Project, rootDir string) (*Project, error) {
+	p.CreatedAt = time.Now()
 	p.SetRootDir(rootDir)
 	err := p.initStorage()
 	if err != nil {
@@ -100,11 +129,39 @@ func (p *Project) initStorage() error {
 	return nil
 }
 
-func (p *Project) SaveImage(image []byte) error {
+// SaveImage 保存照片并加入视频，meta 中的拍摄信息连同项目名、序号写入 EXIF
+func (p *Project) SaveImage(image []byte, meta exif.Metadata) error {
 	info, err := p.LoadImageInfo()
 	if err != nil {
 		return err
 	}
+	if meta.Time.IsZero() {
+		meta.Time = time.Now()
+	}
+	rec := &ImageRecord{Number: info.MaxNumber, Time: meta.Time}
+	image, decode := p.calibrateImage(image, decoder(image), rec)
+	if reason := p.checkFilter(decode, rec); reason != "" {
+		return p.saveFiltered(image, rec, reason, info)
+	}
+	if rec.Calibration != nil {
+		gains := rec.Calibration.Gains
+		p.calibration = &gains
+	}
+	p.analyzeGrowth(decode, rec)
+	meta.Project = p.Name
+	meta.Sequence = info.MaxNumber
+	meta.Host, _ = os.Hostname()
+	meta.Location = p.Location
+	// frame 为写入视频的帧，为 nil 时与保存的照片相同
+	image, frame := p.applyOverlay(image, meta, info)
+	if data, err := exif.Write(image, meta); err != nil {
+		logger.Warnf("write exif err: %s", err)
+	} else {
+		image = data
+	}
+	if frame == nil {
+		frame = image
+	}
 	name := p.generateImageName(image, info.MaxNumber)
 	if err = os.WriteFile(p.GetImagePath(name), image, consts.DefaultFilePerm); err != nil {
 		return err
@@ -115,44 +172,100 @@ func (p *Project) SaveImage(image []byte) error {
 	if err = p.dumpImageInfo(info, true); err != nil {
 		return err
 	}
-	if p.Video.Enable {
-		if p.video == nil {
-			logger.Info("create video")
-			if err = p.NewVideoBuilder(); err != nil {
-				return err
-			}
-		} else if p.video.GetCnt() >= p.Video.MaxImage {
-			logger.Info("save video")
-			err = p.video.Close()
-			if err != nil {
-				logger.Errorf("vide close err: %s", err)
-			}
-			if err = p.NewVideoBuilder(); err != nil {
-				return err
-			}
-		}
+	rec.Name = name
+	// 先写入视频，索引中记录照片所在的视频分段，用于修复损坏的分段
+	verr := p.addVideoFrame(frame)
+	if verr == nil && p.video != nil {
+		rec.Video = path.Base(p.video.Path())
+	}
+	if err = p.appendIndex(rec); err != nil {
+		logger.Warnf("append image index err: %s", err)
+	}
+
+	return verr
+}
 
-		if err = p.video.Add(image); err != nil {
+func (p *Project) addVideoFrame(frame []byte) error {
+	if !p.Video.Enable {
+		return nil
+	}
+	if p.video == nil {
+		if err := p.resumeVideo(frame); err != nil {
+			logger.Warnf("resume video err: %s", err)
+		}
+	}
+	if p.video == nil {
+		logger.Info("create video")
+		if err := p.NewVideoBuilder(frame); err != nil {
 			return err
 		}
+	} else if p.video.GetCnt() >= p.Video.MaxImage {
+		logger.Info("save video")
+		if err := p.closeVideo(); err != nil {
+			logger.Errorf("vide close err: %s", err)
+		}
+		if err := p.NewVideoBuilder(frame); err != nil {
+			return err
+		}
+	}
+	p.setDeflicker()
+	if err := p.video.Add(frame); err != nil {
+		return err
 	}
 
+	return p.updateVideoInfo(func(info *VideoInfo) {
+		info.Frames = p.video.GetCnt()
+	})
+}
+
+// resumeVideo 按 Video.Resume 策略继续写入上次未写满的分段，尺寸或帧率变化时不继续
+func (p *Project) resumeVideo(frame []byte) error {
+	if p.Video.Resume == types.VideoResumeNew {
+		return nil
+	}
+	info, err := p.loadVideoInfo()
+	if err != nil {
+		return err
+	}
+	if info.Current == "" || info.Frames >= p.Video.MaxImage {
+		return nil
+	}
+	width, height, err := video.FrameSize(frame)
+	if err != nil {
+		return err
+	}
+	b, err := video.Resume(p.GetVideoPath(info.Current))
+	if err != nil {
+		return err
+	}
+	if w, h := b.Size(); w != width || h != height || b.FPS() != p.Video.FPS || b.GetCnt() >= p.Video.MaxImage {
+		return b.Close()
+	}
+	logger.Infof("resume video %s at frame %d", info.Current, b.GetCnt())
+	p.video = b
+
 	return nil
 }
 
-func (p *Project) NewVideoBuilder() error {
+// NewVideoBuilder 创建新的视频分段，视频尺寸取自 frame
+func (p *Project) NewVideoBuilder(frame []byte) error {
 	info, err := p.loadVideoInfo()
 	if err != nil {
 		return err
 	}
+	width, height, err := video.FrameSize(frame)
+	if err != nil {
+		return err
+	}
 
 	name := p.generateVideoName(info.MaxNumber)
 	logger.Infof("new video builder %s", name)
-	p.video, err = video.NewBuilder(path.Join(p.getVideoDirPath(), name), consts.Width, consts.Height, p.Video.FPS)
+	p.video, err = video.NewBuilder(path.Join(p.getVideoDirPath(), name), width, height, p.Video.FPS)
 	if err != nil {
 		return err
 	}
 	info.MaxNumber++
+	info.Current, info.Frames, info.FullFrames = name, 0, 0
 	if err = p.dumpVideoInfo(info); err != nil {
 		return err
 	}
@@ -160,6 +273,21 @@ func (p *Project) NewVideoBuilder() error {
 	return nil
 }
 
+// setDeflicker 按当前设置为视频分段设置去闪烁。设置变化时重新创建，关闭时丢弃，
+// 之后重新开启或修改设置都从空的亮度窗口开始。
+func (p *Project) setDeflicker() {
+	if !p.Video.Deflicker.Enable {
+		p.deflicker, p.deflickerSetting = nil, types.DeflickerSetting{}
+		p.video.SetDeflicker(nil)
+		return
+	}
+	if p.deflicker == nil || p.deflickerSetting != p.Video.Deflicker || p.deflickerQuality != p.Capture.Quality {
+		p.deflicker = video.NewDeflicker(p.Video.Deflicker, p.Capture.Quality)
+		p.deflickerSetting, p.deflickerQuality = p.Video.Deflicker, p.Capture.Quality
+	}
+	p.video.SetDeflicker(p.deflicker)
+}
+
 func (p *Project) LatestImageName() (string, error) {
 	info, err := p.LoadImageInfo()
 	if err != nil {
@@ -192,10 +320,68 @@ func (p *Project) ListImages(fun func(info fs.FileInfo) error) error {
 	return listFiles(p.getImageDirPath(), consts.DefaultImageExt, fun)
 }
 
+// FilterImages 按 f 筛选照片，时间按文件修改时间（即保存时间）比较
+func (p *Project) FilterImages(f types.ImageFilter) ([]fs.FileInfo, error) {
+	names := make(map[string]bool, len(f.Names))
+	for _, name := range f.Names {
+		names[name] = true
+	}
+	var res []fs.FileInfo
+	err := p.ListImages(func(info fs.FileInfo) error {
+		if len(names) > 0 && !names[info.Name()] {
+			return nil
+		}
+		if n := p.imageNumber(info.Name()); n < f.From || (f.To > 0 && n > f.To) {
+			return nil
+		}
+		if t := info.ModTime(); (!f.Since.IsZero() && t.Before(f.Since)) || (!f.Until.IsZero() && t.After(f.Until)) {
+			return nil
+		}
+		res = append(res, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if f.Every > 1 {
+		every := res[:0]
+		for i := 0; i < len(res); i += f.Every {
+			every = append(every, res[i])
+		}
+		res = every
+	}
+
+	return res, nil
+}
+
 func (p *Project) GetVideoPath(name string) string {
 	return path.Join(p.getVideoDirPath(), name)
 }
 
+// getFullVideoPath 返回第 part 个完整视频的路径，第一个为 <项目名>-full.avi，之后为 <项目名>-full-<part>.avi
+func (p *Project) getFullVideoPath(part int) string {
+	if part <= 1 {
+		return p.GetVideoPath(p.Name + "-full" + consts.DefaultVideoExt)
+	}
+
+	return p.GetVideoPath(fmt.Sprintf("%s-full-%d%s", p.Name, part, consts.DefaultVideoExt))
+}
+
+// IsFullVideo 判断 name 是否为持续追加的完整视频
+func (p *Project) IsFullVideo(name string) bool {
+	base, ok := strings.CutSuffix(name, consts.DefaultVideoExt)
+	if !ok {
+		return false
+	}
+	if base == p.Name+"-full" {
+		return true
+	}
+	part, ok := strings.CutPrefix(base, p.Name+"-full-")
+	n, err := strconv.Atoi(part)
+
+	return ok && err == nil && n > 1
+}
+
 func (p *Project) ListVideos(fun func(info fs.FileInfo) error) error {
 	return listFiles(p.getVideoDirPath(), consts.DefaultVideoExt, fun)
 }
@@ -210,22 +396,77 @@ func (p *Project) ClearImages() error {
 	if err != nil {
 		return err
 	}
+	// 照片编号会重新开始，旧缩略图需要一起清除
+	if err = os.RemoveAll(p.GetThumbDirPath()); err != nil {
+		return err
+	}
+	if err = os.RemoveAll(p.getLowDirPath()); err != nil {
+		return err
+	}
 
 	return p.initStorage()
 }
 
+// ClearVideos 删除所有视频，并清除记录的当前分段与完整视频进度，之后从新的分段与完整视频开始
 func (p *Project) ClearVideos() error {
-	err := os.RemoveAll(p.getVideoDirPath())
+	if p.video != nil {
+		_ = p.video.Close()
+		p.video = nil
+	}
+	// 保留分段编号，新分段不与已下载的旧分段重名
+	info, err := p.loadVideoInfo()
 	if err != nil {
+		info = &VideoInfo{}
+	}
+	if err = os.RemoveAll(p.getVideoDirPath()); err != nil {
+		return err
+	}
+	if err = p.initStorage(); err != nil {
 		return err
 	}
+	info.Current, info.Frames, info.FullFrames, info.FullPart = "", 0, 0, 0
 
-	return p.initStorage()
+	return p.dumpVideoInfo(info)
 }
 
 func (p *Project) Close() error {
-	if p.video != nil {
-		return p.video.Close()
+	return p.closeVideo()
+}
+
+// closeVideo 结束当前视频分段，开启完整视频时将该分段尚未追加的帧追加到完整视频末尾
+func (p *Project) closeVideo() error {
+	if p.video == nil {
+		return nil
+	}
+	chunk, frames := p.video.Path(), p.video.GetCnt()
+	err := p.video.Close()
+	p.video = nil
+	if err != nil {
+		return err
+	}
+
+	var aerr error
+	err = p.updateVideoInfo(func(info *VideoInfo) {
+		info.Frames = frames
+		if !p.Video.Full || frames <= info.FullFrames {
+			return
+		}
+		aerr = video.AppendTail(p.getFullVideoPath(info.FullPart), chunk, info.FullFrames)
+		if errors.Is(aerr, video.ErrTooLarge) {
+			// 完整视频已达到大小上限，之后追加到新的文件
+			info.FullPart = max(info.FullPart, 1) + 1
+			logger.Infof("full video of %s reached size limit, continue with part %d", p.Name, info.FullPart)
+			aerr = video.AppendTail(p.getFullVideoPath(info.FullPart), chunk, info.FullFrames)
+		}
+		if aerr == nil {
+			info.FullFrames = frames
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if aerr != nil {
+		return fmt.Errorf("append full video err: %w", aerr)
 	}
 
 	return nil
@@ -294,6 +535,16 @@ func (p *Project) loadVideoInfo() (*VideoInfo, error) {
 	return info, nil
 }
 
+func (p *Project) updateVideoInfo(fn func(info *VideoInfo)) error {
+	info, err := p.loadVideoInfo()
+	if err != nil {
+		return err
+	}
+	fn(info)
+
+	return p.dumpVideoInfo(info)
+}
+
 func (p *Project) dumpVideoInfo(info *VideoInfo) error {
 	t := time.Now()
 	info.UpdateAt = &t
@@ -313,6 +564,42 @@ func (p *Project) GetImagePath(name string) string {
 	return path.Join(p.rootDir, consts.DefaultImagesDir, name)
 }
 
+// ListExports 列出联系表、动图等导出文件
+func (p *Project) ListExports(fun func(info fs.FileInfo) error) error {
+	if fun == nil {
+		return nil
+	}
+	// 以 . 开头的是正在生成的临时文件
+	err := listFiles(p.GetExportDirPath(), "", func(info fs.FileInfo) error {
+		if strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		return fun(info)
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// GetExportPath 返回导出文件路径，name 不能包含目录
+func (p *Project) GetExportPath(name string) (string, error) {
+	if name == "" || name != path.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid export name %s", name)
+	}
+
+	return path.Join(p.GetExportDirPath(), name), nil
+}
+
+func (p *Project) GetExportDirPath() string {
+	return path.Join(p.rootDir, consts.DefaultExportsDir)
+}
+
+func (p *Project) GetThumbDirPath() string {
+	return path.Join(p.rootDir, consts.DefaultThumbsDir)
+}
+
 func (p *Project) getImageInfoPath() string {
 	return path.Join(p.rootDir, consts.DefaultImagesDir, consts.DefaultInfoFile)
 }
@@ -341,7 +628,8 @@ func listFiles(dir string, ext string, fun func(info fs.FileInfo) error) error {
 		if file.IsDir() {
 			continue
 		}
-		if !strings.HasSuffix(file.Name(), ext) {
+		// 以 . 开头的是写入中的临时文件
+		if !strings.HasSuffix(file.Name(), ext) || strings.HasPrefix(file.Name(), ".") {
 			continue
 		}
 		info, err := file.Info()
@@ -0,0 +1,133 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"plant-shutter-pi/pkg/video"
+)
+
+const (
+	RepairRebuilt     = "rebuilt"
+	RepairRegenerated = "regenerated"
+	RepairRemoved     = "removed"
+	RepairFailed      = "failed"
+)
+
+// RepairResult 为一个视频分段的修复结果
+type RepairResult struct {
+	Video string `json:"video"`
+	// Action 为 rebuilt（重建索引）、regenerated（由照片重新生成）、removed（没有帧的空分段）或 failed
+	Action string `json:"action"`
+	Frames int    `json:"frames"`
+	Error  string `json:"error,omitempty"`
+}
+
+// RepairVideos 修复断电或进程退出时未关闭的视频分段，只返回修改过或修复失败的分段。
+// 需在项目开始拍摄、后台任务开始执行前调用。
+func (p *Project) RepairVideos() ([]RepairResult, error) {
+	// 断电时未完成的视频与导出留下的临时文件
+	for _, dir := range []string{p.getVideoDirPath(), p.GetExportDirPath()} {
+		removeTempFiles(dir)
+	}
+	var names []string
+	err := p.ListVideos(func(info fs.FileInfo) error {
+		names = append(names, info.Name())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var res []RepairResult
+	for _, name := range names {
+		r := RepairResult{Video: name, Action: RepairRebuilt}
+		frames, repaired, err := video.Repair(p.GetVideoPath(name))
+		if err == nil && !repaired {
+			continue
+		}
+		r.Frames = frames
+		// 头部不完整或没有完整的帧时，由索引中记录的照片重新生成
+		if errors.Is(err, video.ErrNotAvi) || (err == nil && frames == 0) {
+			r.Action, r.Frames, err = p.regenerateVideo(name)
+		}
+		if err != nil {
+			r.Action, r.Error = RepairFailed, err.Error()
+		}
+		logger.Infof("repair video %s of %s: %s, %d frames", name, p.Name, r.Action, r.Frames)
+		res = append(res, r)
+	}
+
+	return res, nil
+}
+
+// regenerateVideo 由写入该分段的照片重新生成视频，没有照片时删除分段。
+// 重新生成的视频不含只叠加在视频上的文字。
+func (p *Project) regenerateVideo(name string) (string, int, error) {
+	var images []string
+	err := p.LoadIndex(func(r *ImageRecord) error {
+		if r.Video == name {
+			images = append(images, r.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	output := p.GetVideoPath(name)
+	if len(images) == 0 {
+		return RepairRemoved, 0, os.Remove(output)
+	}
+	tmp := path.Join(path.Dir(output), "."+name)
+	defer os.Remove(tmp)
+	var b *video.Builder
+	for _, image := range images {
+		frame, err := p.GetImage(image)
+		if err != nil {
+			logger.Warnf("regenerate video %s: %s", name, err)
+			continue
+		}
+		if b == nil {
+			width, height, err := video.FrameSize(frame)
+			if err != nil {
+				return "", 0, err
+			}
+			if b, err = video.NewBuilder(tmp, width, height, p.Video.FPS); err != nil {
+				return "", 0, err
+			}
+		}
+		if err = b.Add(frame); err != nil {
+			_ = b.Close()
+			return "", 0, err
+		}
+	}
+	if b == nil {
+		return "", 0, fmt.Errorf("no images of %s found", name)
+	}
+	if err = b.Close(); err != nil {
+		return "", 0, err
+	}
+
+	return RepairRegenerated, b.GetCnt(), os.Rename(tmp, output)
+}
+
+// removeTempFiles 删除 dir 中以 . 开头的临时文件
+func removeTempFiles(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if err = os.Remove(path.Join(dir, e.Name())); err != nil {
+			logger.Warnf("remove temp file %s: %s", e.Name(), err)
+		} else {
+			logger.Infof("removed temp file %s", path.Join(dir, e.Name()))
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package project
+
+import (
+	"image"
+
+	"plant-shutter-pi/pkg/exif"
+	"plant-shutter-pi/pkg/imaging"
+	"plant-shutter-pi/pkg/types"
+)
+
+// IsView 是否为派生项目
+func (p *Project) IsView() bool {
+	return p.Parent != ""
+}
+
+// SaveView 从父项目的照片 src 中裁剪缩放出本项目的照片并保存
+func (p *Project) SaveView(src image.Image, meta exif.Metadata) error {
+	data, err := RenderView(src, p.View)
+	if err != nil {
+		return err
+	}
+
+	return p.SaveImage(data, meta)
+}
+
+// RenderView 按派生设置 v 裁剪缩放 src 并编码为 JPEG
+func RenderView(src image.Image, v *types.ViewSetting) ([]byte, error) {
+	img, err := imaging.Crop(src, v.Crop)
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	w, h := imaging.FitSize(b.Dx(), b.Dy(), v.Width, v.Height)
+
+	return imaging.Encode(imaging.Resize(img, w, h), v.Quality)
+}
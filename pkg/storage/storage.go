@@ -4,12 +4,13 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/goccy/go-json"
 
 	"plant-shutter-pi/pkg/storage/consts"
 	"plant-shutter-pi/pkg/storage/project"
-	"plant-shutter-pi/pkg/types"
 	"plant-shutter-pi/pkg/utils"
 )
 
@@ -70,17 +71,34 @@ func (s *Storage) GetProject(name string) (*project.Project, error) {
 	return nil, nil
 }
 
-func (s *Storage) NewProject(name, info string, interval int, camera types.CameraSettings, video types.VideoSetting) (*project.Project, error) {
+// ListViews 返回以 parent 为父项目的派生项目
+func (s *Storage) ListViews(parent string) ([]*project.Project, error) {
 	list, err := s.ListProjects()
 	if err != nil {
 		return nil, err
 	}
+	var res []*project.Project
 	for _, p := range list {
-		if p.Name == name {
+		if p.Parent == parent {
+			res = append(res, p)
+		}
+	}
+
+	return res, nil
+}
+
+// NewProject 按 p 中的设置创建项目
+func (s *Storage) NewProject(p *project.Project) (*project.Project, error) {
+	list, err := s.ListProjects()
+	if err != nil {
+		return nil, err
+	}
+	for _, pj := range list {
+		if pj.Name == p.Name {
 			return nil, fmt.Errorf("project name already exists")
 		}
 	}
-	p, err := project.New(name, info, interval, s.rootDir, camera, video)
+	p, err = project.New(p, s.rootDir)
 	if err != nil {
 		return nil, err
 	}
@@ -155,6 +173,25 @@ func (s *Storage) SetLastRunningProject(name string) error {
 	return s.dumpLastRunning(LastInfo{LastRunning: p})
 }
 
+// SaveSnapshot 保存单张快照，返回文件名
+func (s *Storage) SaveSnapshot(prefix string, image []byte) (string, error) {
+	if err := utils.MkdirAll(s.getSnapshotDirPath()); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s-%s%s", prefix, time.Now().Format("20060102-150405.000"), consts.DefaultImageExt)
+
+	return name, os.WriteFile(path.Join(s.getSnapshotDirPath(), name), image, consts.DefaultFilePerm)
+}
+
+// GetSnapshotPath 返回快照文件路径，name 不能包含目录
+func (s *Storage) GetSnapshotPath(name string) (string, error) {
+	if name != path.Base(name) || !strings.HasSuffix(name, consts.DefaultImageExt) {
+		return "", fmt.Errorf("invalid snapshot name %s", name)
+	}
+
+	return path.Join(s.getSnapshotDirPath(), name), nil
+}
+
 func (s *Storage) dumpList(list []*project.Project) error {
 	f, err := os.Create(s.getProjectInfoPath())
 	if err != nil {
@@ -178,6 +215,10 @@ func (s *Storage) getProjectInfoPath() string {
 	return path.Join(s.rootDir, consts.DefaultInfoFile)
 }
 
+func (s *Storage) getSnapshotDirPath() string {
+	return path.Join(s.rootDir, consts.DefaultSnapshotsDir)
+}
+
 func (s *Storage) getProjectLastRunningPath() string {
 	return path.Join(s.rootDir, consts.DefaultLastRunningFile)
 }
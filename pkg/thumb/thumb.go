@@ -0,0 +1,166 @@
+package thumb
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"plant-shutter-pi/pkg/imaging"
+	"plant-shutter-pi/pkg/storage/consts"
+	"plant-shutter-pi/pkg/utils"
+)
+
+const DefaultQuality = 80
+
+// Cache 将缩略图缓存在磁盘上，每个目录超过 maxBytes 时淘汰最久未访问的文件
+type Cache struct {
+	maxBytes int64
+
+	lock sync.Mutex
+	// sizes 记录各目录的缓存大小，可能偏大（目录被外部清空），淘汰时会重新统计
+	sizes map[string]int64
+}
+
+func New(maxBytes int64) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		sizes:    make(map[string]int64),
+	}
+}
+
+// Get 返回 dir 中 name 照片的 width x height 缩略图（保持比例、不放大），不存在时由 load 读取原图生成
+func (c *Cache) Get(dir, name string, width, height, quality int, load func() ([]byte, error)) ([]byte, error) {
+	if quality <= 0 {
+		quality = DefaultQuality
+	}
+	p := path.Join(dir, key(name, width, height, quality))
+	if data, err := os.ReadFile(p); err == nil {
+		now := time.Now()
+		_ = os.Chtimes(p, now, now)
+		return data, nil
+	}
+
+	src, err := load()
+	if err != nil {
+		return nil, err
+	}
+	data, err := Render(src, width, height, quality)
+	if err != nil {
+		return nil, err
+	}
+	if err = utils.MkdirAll(dir); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(p, data, consts.DefaultFilePerm); err != nil {
+		return nil, err
+	}
+	c.added(dir, int64(len(data)))
+
+	return data, nil
+}
+
+// Remove 删除 name 照片的所有缩略图
+func (c *Cache) Remove(dir, name string) {
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if thumbOf(e.Name(), name) {
+			_ = os.Remove(path.Join(dir, e.Name()))
+		}
+	}
+}
+
+// Render 将 JPEG 缩小到 width x height 以内，为 0 的一边按比例计算
+func Render(src []byte, width, height, quality int) ([]byte, error) {
+	img, err := imaging.Decode(src)
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	w, h := Fit(b.Dx(), b.Dy(), width, height)
+
+	return imaging.Encode(imaging.Resize(img, w, h), quality)
+}
+
+// Fit 计算保持比例缩小到 width x height 以内的尺寸，不放大
+func Fit(srcW, srcH, width, height int) (int, int) {
+	if width <= 0 || width > srcW {
+		width = srcW
+	}
+	if height <= 0 || height > srcH {
+		height = srcH
+	}
+	if width*srcH > height*srcW {
+		return max(1, srcW*height/srcH), height
+	}
+
+	return width, max(1, srcH*width/srcW)
+}
+
+func key(name string, width, height, quality int) string {
+	return fmt.Sprintf("%dx%d-q%d-%s", width, height, quality, name)
+}
+
+// thumbOf 判断缓存文件 file 是否为 name 的缩略图
+func thumbOf(file, name string) bool {
+	var width, height, quality int
+	n, err := fmt.Sscanf(file, "%dx%d-q%d-", &width, &height, &quality)
+
+	return err == nil && n == 3 && file == key(name, width, height, quality)
+}
+
+func (c *Cache) added(dir string, n int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	size, ok := c.sizes[dir]
+	if ok {
+		size += n
+	} else {
+		size = c.maxBytes + 1
+	}
+	if size > c.maxBytes {
+		size = c.evict(dir)
+	}
+	c.sizes[dir] = size
+}
+
+// evict 统计目录大小并按访问时间淘汰，返回剩余大小
+func (c *Cache) evict(dir string) int64 {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	type file struct {
+		name string
+		size int64
+		time time.Time
+	}
+	var (
+		files []file
+		total int64
+	)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || info.IsDir() {
+			continue
+		}
+		files = append(files, file{name: e.Name(), size: info.Size(), time: info.ModTime()})
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].time.Before(files[j].time)
+	})
+	for _, f := range files {
+		if total <= c.maxBytes {
+			break
+		}
+		if err = os.Remove(path.Join(dir, f.name)); err == nil {
+			total -= f.size
+		}
+	}
+
+	return total
+}
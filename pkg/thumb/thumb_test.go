@@ -0,0 +1,71 @@
+package thumb
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 400, 300)), nil); err != nil {
+		t.Fatal(err)
+	}
+	loads := 0
+	load := func() ([]byte, error) {
+		loads++
+		return buf.Bytes(), nil
+	}
+
+	dir := t.TempDir()
+	c := New(1 << 20)
+	data, err := c.Get(dir, "a.jpg", 100, 0, 0, load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 100 || cfg.Height != 75 {
+		t.Fatalf("got %d*%d", cfg.Width, cfg.Height)
+	}
+
+	// 超过上限后最久未访问的缩略图被淘汰
+	c = New(int64(len(data)))
+	if _, err = c.Get(dir, "a.jpg", 100, 0, 0, load); err != nil {
+		t.Fatal(err)
+	}
+	if loads != 1 {
+		t.Fatalf("cached thumbnail should not be rendered again, loads=%d", loads)
+	}
+	if _, err = c.Get(dir, "b.jpg", 100, 0, 0, load); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("got %d cached files, want 1", len(entries))
+	}
+
+	// 只删除该照片的缩略图，名称中的通配符按原样匹配
+	c = New(1 << 20)
+	for _, name := range []string{"[a].jpg", "x-[a].jpg", "a.jpg"} {
+		if _, err = c.Get(dir, name, 100, 0, 0, load); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Remove(dir, "[a].jpg")
+	if _, err = os.Stat(path.Join(dir, key("[a].jpg", 100, 0, DefaultQuality))); !os.IsNotExist(err) {
+		t.Fatalf("thumbnail not removed: %v", err)
+	}
+	if entries, _ = os.ReadDir(dir); len(entries) != 3 {
+		t.Fatalf("got %d cached files, want 3", len(entries))
+	}
+
+	if w, h := Fit(400, 300, 1000, 1000); w != 400 || h != 300 {
+		t.Fatalf("should not upscale, got %d*%d", w, h)
+	}
+}
@@ -11,12 +11,181 @@ type VideoSetting struct {
 	ShootingDays       float32 `json:"shootingDays"`
 	TotalVideoLength   float32 `json:"totalVideoLength"`
 	PreviewVideoLength float32 `json:"previewVideoLength"`
+	// Deflicker 按亮度的滑动平均校正帧间闪烁
+	Deflicker DeflickerSetting `json:"deflicker"`
+	// Full 每个分段结束后追加到完整视频 <项目名>-full.avi，始终有一个完整的视频可供下载；
+	// 达到 AVI 大小上限后依次写入 <项目名>-full-2.avi 等
+	Full bool `json:"full"`
+	// Resume 项目重新开始时的分段策略：continue 继续写入未满的最后一个分段（默认），new 总是开始新分段
+	Resume string `json:"resume" binding:"omitempty,oneof=continue new"`
+}
+
+const (
+	VideoResumeContinue = "continue"
+	VideoResumeNew      = "new"
+)
+
+// SpeedRamp 渲染视频时 [Start, End) 范围内的照片以 Speed 倍速播放，小于 1 为慢放
+type SpeedRamp struct {
+	Start int     `json:"start" binding:"min=0"`
+	End   int     `json:"end" binding:"gtfield=Start"`
+	Speed float64 `json:"speed" binding:"gt=0,max=20"`
+}
+
+// DeflickerSetting 去闪烁设置：以窗口内的平均亮度为目标调整每一帧的增益
+type DeflickerSetting struct {
+	Enable bool `json:"enable"`
+	// Window 窗口帧数，为 0 时为 15
+	Window int `json:"window" binding:"min=0,max=500"`
+	// Strength 校正强度 0-1，为 0 时为 1（完全校正到窗口平均亮度）
+	Strength float64 `json:"strength" binding:"min=0,max=1"`
 }
 
 type CameraSettings map[uint32]int32
 
+type Rect struct {
+	Left   int `json:"left"`
+	Top    int `json:"top"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// Empty 零值表示未设置
+func (r Rect) Empty() bool {
+	return r.Width <= 0 || r.Height <= 0
+}
+
+type CaptureSetting struct {
+	// 拍照分辨率，为 0 时使用摄像头最大分辨率
+	Width  int `json:"width" binding:"min=0"`
+	Height int `json:"height" binding:"min=0"`
+	// Format 源像素格式，为空时使用 JPEG；非 JPEG 的源在程序中转换为 JPEG
+	Format string `json:"format" binding:"omitempty,oneof=JPEG MJPG YUYV"`
+	// Crop 传感器裁剪区域，为空时不裁剪
+	Crop Rect `json:"crop"`
+	// Quality 转换为 JPEG 时的质量(1-100)，为 0 时使用默认值
+	Quality int `json:"quality" binding:"min=0,max=100"`
+}
+
+// ViewSetting 派生项目的设置：从父项目的每张照片中裁剪并缩放得到自己的照片
+type ViewSetting struct {
+	// Crop 父项目照片中的裁剪区域
+	Crop Rect `json:"crop"`
+	// 输出尺寸，都为 0 时与裁剪区域相同，只指定一边时按比例计算另一边
+	Width  int `json:"width" binding:"min=0"`
+	Height int `json:"height" binding:"min=0"`
+	// Quality JPEG 质量(1-100)，为 0 时使用默认值
+	Quality int `json:"quality" binding:"min=0,max=100"`
+}
+
+const (
+	OverlayImages = "images"
+	OverlayVideo  = "video"
+	OverlayBoth   = "both"
+)
+
+// OverlaySetting 叠加到照片或视频帧上的文字
+type OverlaySetting struct {
+	Enable bool `json:"enable"`
+	// Target 叠加到 images（保存的照片）、video（仅视频帧，照片保持原样）或 both，为空时为 video
+	Target string `json:"target" binding:"omitempty,oneof=images video both"`
+	// 拍摄日期时间、项目开始以来的天数、项目名称
+	Time bool `json:"time"`
+	Day  bool `json:"day"`
+	Name bool `json:"name"`
+	// Text 自定义文字
+	Text string `json:"text"`
+	// Position 位置，为空时为 bottom-left
+	Position string `json:"position" binding:"omitempty,oneof=top-left top-right bottom-left bottom-right"`
+	// FontSize 字号(px)，为 0 时按画面高度自动计算
+	FontSize int `json:"fontSize" binding:"min=0"`
+	// Color 文字颜色 #RRGGBB 或 #RRGGBBAA，为空时为白色
+	Color string `json:"color"`
+}
+
+// FilterSetting 过滤与上一张相比没有变化或过暗（例如夜间关灯）的照片
+type FilterSetting struct {
+	Enable bool `json:"enable"`
+	// Threshold 缩小后与上一张保存的照片的平均亮度差(0-255)低于该值时视为没有变化，为 0 时不比较
+	Threshold float64 `json:"threshold" binding:"min=0,max=255"`
+	// MinBrightness 平均亮度(0-255)低于该值时视为过暗，为 0 时不检查
+	MinBrightness float64 `json:"minBrightness" binding:"min=0,max=255"`
+	// Action 为 skip 时丢弃，为 low 时保存到 low 目录（不编号、不加入视频），为空时为 skip
+	Action string `json:"action" binding:"omitempty,oneof=skip low"`
+	// MaxSkip 连续过滤这么多张后强制保存一张，为 0 时不限制
+	MaxSkip int `json:"maxSkip" binding:"min=0"`
+}
+
+const (
+	FilterSkip = "skip"
+	FilterLow  = "low"
+)
+
+// GrowthSetting 按颜色阈值分割绿色叶片，测量叶面积、高度与覆盖率
+type GrowthSetting struct {
+	Enable bool `json:"enable"`
+	// Method 为 exg（过绿指数 2G-R-B）或 hsv，为空时为 exg
+	Method string `json:"method" binding:"omitempty,oneof=exg hsv"`
+	// Threshold ExG 阈值，为 0 时为 20
+	Threshold int `json:"threshold" binding:"min=0,max=510"`
+	// HSV 范围：色相(0-360)，为 0 时为 60-180；最低饱和度与明度(0-255)
+	HueMin int `json:"hueMin" binding:"min=0,max=360"`
+	HueMax int `json:"hueMax" binding:"min=0,max=360"`
+	SatMin int `json:"satMin" binding:"min=0,max=255"`
+	ValMin int `json:"valMin" binding:"min=0,max=255"`
+	// ROIs 分析区域，为空时分析整张照片
+	ROIs []GrowthROI `json:"rois" binding:"dive"`
+	// Width 分析前缩小到的宽度，为 0 时为 640；结果仍换算为原图像素
+	Width int `json:"width" binding:"min=0"`
+}
+
+type GrowthROI struct {
+	Name string `json:"name" binding:"required"`
+	Rect Rect   `json:"rect"`
+}
+
+const (
+	GrowthExG = "exg"
+	GrowthHSV = "hsv"
+)
+
+// CalibrationSetting 用画面中的灰卡校正白平衡与亮度漂移
+type CalibrationSetting struct {
+	Enable bool `json:"enable"`
+	// Patch 灰卡（或色卡中的灰色块）在照片中的区域
+	Patch Rect `json:"patch"`
+	// Target 灰卡校正后的亮度(0-255)，为 0 时只校正颜色、保持灰卡原亮度
+	Target int `json:"target" binding:"min=0,max=255"`
+	// Smoothing 0-1，越大越平滑：增益 = 上一张的增益*Smoothing + 本张测得的增益*(1-Smoothing)，为 0 时逐张校正
+	Smoothing float64 `json:"smoothing" binding:"min=0,max=1"`
+}
+
+// Location 拍摄地点，写入照片的 GPS 信息
+type Location struct {
+	Latitude  float64 `json:"latitude" binding:"min=-90,max=90"`
+	Longitude float64 `json:"longitude" binding:"min=-180,max=180"`
+	// Altitude 海拔(m)
+	Altitude float64 `json:"altitude"`
+}
+
 type File struct {
 	Name    string    `json:"name"`
 	Size    string    `json:"size"`
 	ModTime time.Time `json:"modTime"`
+	// Thumbnail 照片缩略图地址
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+// ImageFilter 照片筛选条件，零值表示不限
+type ImageFilter struct {
+	// From、To 照片编号范围（含两端），To 为 0 时不限
+	From int `form:"from" binding:"min=0"`
+	To   int `form:"to" binding:"min=0"`
+	// Since、Until 拍摄时间范围（RFC3339）
+	Since time.Time `form:"since"`
+	Until time.Time `form:"until"`
+	// Every 在其余条件筛选后每 Every 张取一张
+	Every int `form:"every" binding:"min=0"`
+	// Names 只包含这些照片，可重复传入
+	Names []string `form:"name"`
 }
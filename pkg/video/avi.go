@@ -0,0 +1,512 @@
+package video
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// 与 github.com/icza/mjpeg 写出的文件结构一致：RIFF AVI / LIST hdrl / LIST movi(00dc...) / idx1
+const (
+	frameChunkID = "00dc"
+	// AVI 中的偏移为 32 位，与 mjpeg 保持相同的上限
+	maxAviSize = 4200000000
+	keyFrame   = 0x10
+	idxSuffix  = ".idx_"
+)
+
+var (
+	ErrNotAvi = errors.New("not an avi file")
+	// ErrTooLarge 写入后将超过 AVI 的大小上限
+	ErrTooLarge = errors.New("video file too large")
+)
+
+// aviFile 为解析出的 AVI 文件结构
+type aviFile struct {
+	width, height, fps int
+
+	// avih 与 strh 中帧数字段的位置
+	framesPos, framesPos2 int64
+	// moviPos 为 "movi" 的位置，帧在索引中的偏移相对于它
+	moviPos int64
+	// dataEnd 为最后一个完整帧之后的位置
+	dataEnd int64
+	frames  []aviFrame
+	// indexed 文件完整：帧数、索引与长度字段都已写入
+	indexed bool
+}
+
+type aviFrame struct {
+	// offset 为帧块相对 moviPos 的偏移，size 为 JPEG 数据长度
+	offset int64
+	size   int64
+}
+
+// parseAvi 解析 AVI 文件；未正常关闭的文件长度字段为 0，此时扫描到文件末尾
+func parseAvi(f *os.File) (*aviFile, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	fileSize := st.Size()
+	head := make([]byte, 12)
+	if _, err = f.ReadAt(head, 0); err != nil || string(head[:4]) != "RIFF" || string(head[8:]) != "AVI " {
+		return nil, ErrNotAvi
+	}
+	riffEnd := int64(binary.LittleEndian.Uint32(head[4:])) + 8
+	if riffEnd <= 12 || riffEnd > fileSize {
+		riffEnd = fileSize
+	}
+
+	a := &aviFile{}
+	buf := make([]byte, 12)
+loop:
+	for pos := int64(12); pos+8 <= riffEnd; {
+		if _, err = f.ReadAt(buf[:8], pos); err != nil {
+			return nil, err
+		}
+		id, size := string(buf[:4]), int64(binary.LittleEndian.Uint32(buf[4:8]))
+		switch {
+		case id == "LIST":
+			if _, err = f.ReadAt(buf[8:12], pos+8); err != nil {
+				return nil, err
+			}
+			switch string(buf[8:12]) {
+			case "hdrl":
+				if err = a.parseHeader(f, pos+12, pos+8+size); err != nil {
+					return nil, err
+				}
+			case "movi":
+				a.moviPos = pos + 8
+				end := pos + 8 + size
+				if size == 0 || end > fileSize {
+					end = fileSize
+					size = 0
+				}
+				if err = a.parseMovi(f, end); err != nil {
+					return nil, err
+				}
+				if end == fileSize && size == 0 {
+					break loop
+				}
+			}
+		case id == "idx1":
+			a.indexed = binary.LittleEndian.Uint32(head[4:]) != 0 && pos+8+size <= fileSize
+		}
+		pos += 8 + size + size&1
+	}
+	if a.moviPos == 0 || a.framesPos == 0 {
+		return nil, ErrNotAvi
+	}
+
+	return a, nil
+}
+
+func (a *aviFile) parseHeader(f *os.File, pos, end int64) error {
+	buf := make([]byte, 12)
+	for pos+8 <= end {
+		if _, err := f.ReadAt(buf[:8], pos); err != nil {
+			return err
+		}
+		id, size := string(buf[:4]), int64(binary.LittleEndian.Uint32(buf[4:8]))
+		switch id {
+		case "avih":
+			data := make([]byte, 40)
+			if _, err := f.ReadAt(data, pos+8); err != nil {
+				return err
+			}
+			a.framesPos = pos + 8 + 16
+			a.width = int(binary.LittleEndian.Uint32(data[32:]))
+			a.height = int(binary.LittleEndian.Uint32(data[36:]))
+		case "LIST":
+			// strl
+			return a.parseHeader(f, pos+12, pos+8+size)
+		case "strh":
+			data := make([]byte, 36)
+			if _, err := f.ReadAt(data, pos+8); err != nil {
+				return err
+			}
+			scale, rate := binary.LittleEndian.Uint32(data[20:]), binary.LittleEndian.Uint32(data[24:])
+			if scale > 0 {
+				a.fps = int(rate / scale)
+			}
+			a.framesPos2 = pos + 8 + 32
+		}
+		pos += 8 + size + size&1
+	}
+
+	return nil
+}
+
+// parseMovi 记录所有完整的帧，遇到不完整或无法识别的块时停止
+func (a *aviFile) parseMovi(f *os.File, end int64) error {
+	buf := make([]byte, 8)
+	pos := a.moviPos + 4
+	a.dataEnd = pos
+	for pos+8 <= end {
+		if _, err := f.ReadAt(buf, pos); err != nil {
+			return err
+		}
+		id, size := string(buf[:4]), int64(binary.LittleEndian.Uint32(buf[4:]))
+		if !validChunkID(id) || pos+8+size > end {
+			break
+		}
+		if id == frameChunkID {
+			if size == 0 {
+				// 写入帧数据前断电，长度字段仍为 0
+				break
+			}
+			a.frames = append(a.frames, aviFrame{offset: pos - a.moviPos, size: size})
+		}
+		pos += 8 + size + size&1
+		a.dataEnd = min(pos, end)
+	}
+
+	return nil
+}
+
+func validChunkID(id string) bool {
+	for i := 0; i < len(id); i++ {
+		if id[i] < ' ' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// finish 截断到最后一个完整帧之后，写入 idx1 索引并更新长度与帧数字段
+func (a *aviFile) finish(f *os.File) error {
+	if err := f.Truncate(a.dataEnd); err != nil {
+		return err
+	}
+	pos := a.dataEnd
+
+	idx := make([]byte, 8+16*len(a.frames))
+	copy(idx, "idx1")
+	binary.LittleEndian.PutUint32(idx[4:], uint32(16*len(a.frames)))
+	for i, fr := range a.frames {
+		e := idx[8+16*i:]
+		copy(e, frameChunkID)
+		binary.LittleEndian.PutUint32(e[4:], keyFrame)
+		binary.LittleEndian.PutUint32(e[8:], uint32(fr.offset))
+		binary.LittleEndian.PutUint32(e[12:], uint32(fr.size))
+	}
+	if _, err := f.WriteAt(idx, pos); err != nil {
+		return err
+	}
+	fileEnd := pos + int64(len(idx))
+
+	fields := []struct {
+		pos   int64
+		value int64
+	}{
+		{4, fileEnd - 8},
+		{a.moviPos - 4, a.dataEnd - a.moviPos},
+		{a.framesPos, int64(len(a.frames))},
+		{a.framesPos2, int64(len(a.frames))},
+	}
+	b := make([]byte, 4)
+	for _, field := range fields {
+		binary.LittleEndian.PutUint32(b, uint32(field.value))
+		if _, err := f.WriteAt(b, field.pos); err != nil {
+			return err
+		}
+	}
+	a.indexed = true
+
+	return f.Sync()
+}
+
+// source 为待追加的源文件，frames 为需要复制的帧
+type source struct {
+	name   string
+	f      *os.File
+	a      *aviFile
+	frames []aviFrame
+}
+
+// openSource 打开并解析 src，检查尺寸是否与 a 一致
+func (a *aviFile) openSource(src string, skip int) (*source, error) {
+	sf, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	s, err := parseAvi(sf)
+	if err != nil {
+		_ = sf.Close()
+		return nil, fmt.Errorf("%s: %w", src, err)
+	}
+	if s.width != a.width || s.height != a.height {
+		_ = sf.Close()
+		return nil, fmt.Errorf("%s: size %dx%d differs from %dx%d", src, s.width, s.height, a.width, a.height)
+	}
+
+	return &source{name: src, f: sf, a: s, frames: s.frames[min(skip, len(s.frames)):]}, nil
+}
+
+// appendFrom 将 s 的帧原样复制到 f 的末尾，每复制一帧调用一次 step，ctx 取消时停止
+func (a *aviFile) appendFrom(ctx context.Context, f *os.File, s *source, step func()) error {
+	pos := a.dataEnd
+	for _, fr := range s.frames {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// 未正常关闭的源文件末尾可能没有补齐字节，只复制块头与数据，补齐字节单独写入
+		n, err := io.Copy(io.NewOffsetWriter(f, pos), io.NewSectionReader(s.f, s.a.moviPos+fr.offset, 8+fr.size))
+		if err != nil {
+			return err
+		}
+		if n != 8+fr.size {
+			return fmt.Errorf("%s: short frame", s.name)
+		}
+		if fr.size&1 == 1 {
+			if _, err = f.WriteAt([]byte{0}, pos+8+fr.size); err != nil {
+				return err
+			}
+		}
+		a.frames = append(a.frames, aviFrame{offset: pos - a.moviPos, size: fr.size})
+		pos += 8 + fr.size + fr.size&1
+		a.dataEnd = pos
+		step()
+	}
+
+	return nil
+}
+
+// appender 在已有的 AVI 末尾继续写入帧，实现 mjpeg.AviWriter
+type appender struct {
+	f *os.File
+	a *aviFile
+}
+
+// openAppender 打开 path 继续写入。先清零长度字段，断电后会按未关闭的文件修复。
+func openAppender(path string) (*appender, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	a, err := parseAvi(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err = f.Truncate(a.dataEnd); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	zero := make([]byte, 4)
+	for _, pos := range []int64{4, a.moviPos - 4} {
+		if _, err = f.WriteAt(zero, pos); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+	a.indexed = false
+
+	return &appender{f: f, a: a}, nil
+}
+
+func (w *appender) AddFrame(jpegData []byte) error {
+	size := int64(len(jpegData))
+	chunk := 8 + size + size&1
+	if w.a.dataEnd+chunk+int64(len(w.a.frames)+1)*16 > maxAviSize {
+		return ErrTooLarge
+	}
+	buf := make([]byte, chunk)
+	copy(buf, frameChunkID)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(size))
+	copy(buf[8:], jpegData)
+	if _, err := w.f.WriteAt(buf, w.a.dataEnd); err != nil {
+		return err
+	}
+	w.a.frames = append(w.a.frames, aviFrame{offset: w.a.dataEnd - w.a.moviPos, size: size})
+	w.a.dataEnd += chunk
+
+	return nil
+}
+
+func (w *appender) Close() error {
+	err := w.a.finish(w.f)
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Frames 返回 AVI 中完整的帧数
+func Frames(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	a, err := parseAvi(f)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(a.frames), nil
+}
+
+// ReadFrames 依次读取 AVI 中的 JPEG 帧
+func ReadFrames(path string, fn func(frame []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	a, err := parseAvi(f)
+	if err != nil {
+		return err
+	}
+	for _, fr := range a.frames {
+		frame := make([]byte, fr.size)
+		if _, err = f.ReadAt(frame, a.moviPos+fr.offset+8); err != nil {
+			return err
+		}
+		if err = fn(frame); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Repair 修复未正常关闭的 AVI：丢弃不完整的最后一帧，重建 idx1 索引与长度、帧数字段。
+// 返回完整的帧数，repaired 表示文件被修改过。
+func Repair(path string) (frames int, repaired bool, err error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+	a, err := parseAvi(f)
+	if err == nil && a.indexed {
+		return len(a.frames), false, nil
+	}
+	// 未正常关闭时留下的 mjpeg 临时索引文件不再需要
+	if rerr := os.Remove(path + idxSuffix); rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+		return 0, false, rerr
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if err = a.finish(f); err != nil {
+		return 0, false, err
+	}
+
+	return len(a.frames), true, nil
+}
+
+// Append 将 srcs 的帧不经解码追加到 dst 末尾；dst 不存在时按第一个文件的尺寸与帧率创建
+func Append(dst string, srcs ...string) error {
+	return appendTo(context.Background(), dst, 0, srcs, nil)
+}
+
+// AppendTail 只将 src 中第 skip 帧之后的帧追加到 dst，用于 src 之前已部分追加过的情况
+func AppendTail(dst, src string, skip int) error {
+	return appendTo(context.Background(), dst, skip, []string{src}, nil)
+}
+
+// appendTo 先检查所有源文件与追加后的大小再写入；写入失败或 ctx 取消时回滚到追加前的帧，dst 仍是完整的文件。
+// progress 不为 nil 时汇报已追加的帧数。
+func appendTo(ctx context.Context, dst string, skip int, srcs []string, progress func(done, total int)) error {
+	if len(srcs) == 0 {
+		return nil
+	}
+	if _, err := os.Stat(dst); errors.Is(err, os.ErrNotExist) {
+		if err = create(dst, srcs[0]); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(dst, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	a, err := parseAvi(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", dst, err)
+	}
+
+	var sources []*source
+	defer func() {
+		for _, s := range sources {
+			_ = s.f.Close()
+		}
+	}()
+	size, count := a.dataEnd, len(a.frames)
+	for _, src := range srcs {
+		s, err := a.openSource(src, skip)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, s)
+		for _, fr := range s.frames {
+			size += 8 + fr.size + fr.size&1
+		}
+		count += len(s.frames)
+	}
+	if size+8+int64(count)*16 > maxAviSize {
+		return ErrTooLarge
+	}
+
+	frames, dataEnd := len(a.frames), a.dataEnd
+	done, total := 0, count-frames
+	step := func() {
+		done++
+		if progress != nil {
+			progress(done, total)
+		}
+	}
+	for _, s := range sources {
+		if err = a.appendFrom(ctx, f, s, step); err != nil {
+			a.frames, a.dataEnd = a.frames[:frames], dataEnd
+			if ferr := a.finish(f); ferr != nil {
+				return fmt.Errorf("%w, restore %s err: %s", err, dst, ferr)
+			}
+			return err
+		}
+	}
+
+	return a.finish(f)
+}
+
+// Concat 将 srcs 依次拼接为 dst，不解码帧；progress 汇报已复制的帧数，ctx 取消时停止
+func Concat(ctx context.Context, dst string, srcs []string, progress func(done, total int)) error {
+	if len(srcs) == 0 {
+		return errors.New("no videos selected")
+	}
+	if err := create(dst, srcs[0]); err != nil {
+		return err
+	}
+
+	return appendTo(ctx, dst, 0, srcs, progress)
+}
+
+// create 创建与 src 尺寸、帧率相同的空 AVI
+func create(dst, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	a, err := parseAvi(f)
+	_ = f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	if a.fps <= 0 {
+		a.fps = DefaultFPS
+	}
+	b, err := NewBuilder(dst, a.width, a.height, a.fps)
+	if err != nil {
+		return err
+	}
+
+	return b.Close()
+}
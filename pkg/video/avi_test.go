@@ -0,0 +1,212 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path"
+	"testing"
+)
+
+func testFrame(t *testing.T, v uint8) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 24))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{Y: v}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func testVideo(t *testing.T, p string, frames ...[]byte) {
+	b, err := NewBuilder(p, 32, 24, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range frames {
+		if err = b.Add(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = b.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcat(t *testing.T) {
+	dir := t.TempDir()
+	f1, f2, f3 := testFrame(t, 10), testFrame(t, 128), append(testFrame(t, 250), 0)
+	a, b, out := path.Join(dir, "a.avi"), path.Join(dir, "b.avi"), path.Join(dir, "out.avi")
+	testVideo(t, a, f1, f2)
+	testVideo(t, b, f3)
+
+	var done, total int
+	err := Concat(context.Background(), out, []string{a, b}, func(d, n int) {
+		done, total = d, n
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done != 3 || total != 3 {
+		t.Fatalf("got progress %d/%d", done, total)
+	}
+	if err := Append(out, a); err != nil {
+		t.Fatal(err)
+	}
+	var got [][]byte
+	err = ReadFrames(out, func(frame []byte) error {
+		got = append(got, frame)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]byte{f1, f2, f3, f1, f2}
+	if len(got) != len(want) {
+		t.Fatalf("got %d frames, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("frame %d differs", i)
+		}
+	}
+
+	f, err := openAvi(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.indexed || f.fps != 10 || f.width != 32 {
+		t.Fatalf("got %+v", f)
+	}
+
+	// 任一源文件无效时不写入任何帧
+	if err = Append(out, a, path.Join(dir, "missing.avi")); err == nil {
+		t.Fatal("append missing video should fail")
+	}
+	if f, err = openAvi(out); err != nil || !f.indexed || len(f.frames) != len(want) {
+		t.Fatalf("got %+v %v", f, err)
+	}
+	// 取消时回滚已追加的帧
+	ctx, cancel := context.WithCancel(context.Background())
+	err = appendTo(ctx, out, 0, []string{a}, func(done, total int) { cancel() })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v", err)
+	}
+	if f, err = openAvi(out); err != nil || !f.indexed || len(f.frames) != len(want) {
+		t.Fatalf("got %+v %v", f, err)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	p := path.Join(t.TempDir(), "crash.avi")
+	f1, f2 := testFrame(t, 10), testFrame(t, 128)
+	testVideo(t, p, f1, f2, f1)
+
+	// 模拟断电：去掉索引与长度字段，最后一帧只写入一半
+	a, err := openAvi(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := a.frames[2]
+	f, err := os.OpenFile(p, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pos := range []int64{4, a.moviPos - 4, a.framesPos, a.framesPos2} {
+		if _, err = f.WriteAt(make([]byte, 4), pos); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = f.Truncate(a.moviPos + last.offset + 8 + last.size/2); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	n, repaired, err := Repair(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || !repaired {
+		t.Fatalf("got %d frames, repaired %v", n, repaired)
+	}
+	if n, repaired, err = Repair(p); err != nil || n != 2 || repaired {
+		t.Fatalf("second repair: %d %v %v", n, repaired, err)
+	}
+	var got [][]byte
+	err = ReadFrames(p, func(frame []byte) error {
+		got = append(got, frame)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || !bytes.Equal(got[0], f1) || !bytes.Equal(got[1], f2) {
+		t.Fatalf("got %d frames", len(got))
+	}
+}
+
+func TestResume(t *testing.T) {
+	p := path.Join(t.TempDir(), "chunk.avi")
+	f1, f2, f3 := testFrame(t, 10), testFrame(t, 128), append(testFrame(t, 250), 0)
+	testVideo(t, p, f1)
+
+	b, err := Resume(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.GetCnt() != 1 || b.FPS() != 10 {
+		t.Fatalf("got %d frames, fps %d", b.GetCnt(), b.FPS())
+	}
+	for _, f := range [][]byte{f3, f2} {
+		if err = b.Add(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var got [][]byte
+	err = ReadFrames(p, func(frame []byte) error {
+		got = append(got, frame)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]byte{f1, f3, f2}
+	if len(got) != len(want) {
+		t.Fatalf("got %d frames, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("frame %d differs", i)
+		}
+	}
+	if a, err := openAvi(p); err != nil || !a.indexed {
+		t.Fatalf("got %v %v", a, err)
+	}
+
+	full := path.Join(path.Dir(p), "full.avi")
+	if err = AppendTail(full, p, 2); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := Frames(full); err != nil || n != 1 {
+		t.Fatalf("got %d frames %v", n, err)
+	}
+}
+
+func openAvi(p string) (*aviFile, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseAvi(f)
+}
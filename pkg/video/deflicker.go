@@ -0,0 +1,122 @@
+package video
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	"plant-shutter-pi/pkg/filter"
+	"plant-shutter-pi/pkg/imaging"
+	"plant-shutter-pi/pkg/types"
+)
+
+const (
+	defaultWindow = 15
+	// 增益范围，避免全黑的帧被过度提亮
+	minGain = 0.5
+	maxGain = 2
+)
+
+// Deflicker 实时去闪烁：以最近 window 帧（含当前帧）的平均亮度为目标
+type Deflicker struct {
+	window   int
+	strength float64
+	quality  int
+
+	lumas []float64
+}
+
+func NewDeflicker(s types.DeflickerSetting, quality int) *Deflicker {
+	window, strength := params(s)
+
+	return &Deflicker{
+		window:   window,
+		strength: strength,
+		quality:  quality,
+	}
+}
+
+// Apply 返回校正后的帧
+func (d *Deflicker) Apply(frame []byte) ([]byte, error) {
+	img, err := imaging.Decode(frame)
+	if err != nil {
+		return nil, err
+	}
+	luma := Luma(img)
+	d.lumas = append(d.lumas, luma)
+	if len(d.lumas) > d.window {
+		d.lumas = d.lumas[len(d.lumas)-d.window:]
+	}
+	g := gain(mean(d.lumas), luma, d.strength)
+	if g == 1 {
+		return frame, nil
+	}
+
+	return imaging.Encode(ApplyGain(img, g), d.quality)
+}
+
+// SmoothGains 离线去闪烁：以每帧前后各 window/2 帧的平均亮度为目标，返回每帧的增益
+func SmoothGains(lumas []float64, s types.DeflickerSetting) []float64 {
+	window, strength := params(s)
+	half := window / 2
+	res := make([]float64, len(lumas))
+	for i, l := range lumas {
+		from, to := max(0, i-half), min(len(lumas), i+half+1)
+		res[i] = gain(mean(lumas[from:to]), l, strength)
+	}
+
+	return res
+}
+
+// Luma 返回平均亮度 0-255
+func Luma(img image.Image) float64 {
+	return filter.FromImage(img).Brightness
+}
+
+// ApplyGain 将 img 的 RGB 乘以 g
+func ApplyGain(img image.Image, g float64) *image.RGBA {
+	var lut [256]uint8
+	for v := range lut {
+		lut[v] = uint8(min(255, math.Round(float64(v)*g)))
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		dst.Pix[i] = lut[dst.Pix[i]]
+		dst.Pix[i+1] = lut[dst.Pix[i+1]]
+		dst.Pix[i+2] = lut[dst.Pix[i+2]]
+	}
+
+	return dst
+}
+
+func params(s types.DeflickerSetting) (int, float64) {
+	window, strength := s.Window, s.Strength
+	if window <= 0 {
+		window = defaultWindow
+	}
+	if strength <= 0 {
+		strength = 1
+	}
+
+	return window, strength
+}
+
+func gain(target, luma, strength float64) float64 {
+	if luma < 1 {
+		return 1
+	}
+	g := 1 + strength*(target/luma-1)
+
+	return math.Min(maxGain, math.Max(minGain, g))
+}
+
+func mean(v []float64) float64 {
+	sum := 0.0
+	for _, x := range v {
+		sum += x
+	}
+
+	return sum / float64(len(v))
+}
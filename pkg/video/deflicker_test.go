@@ -0,0 +1,23 @@
+package video
+
+import (
+	"testing"
+
+	"plant-shutter-pi/pkg/types"
+)
+
+func TestSmoothGains(t *testing.T) {
+	// 第 3 帧突然变亮
+	gains := SmoothGains([]float64{100, 100, 150, 100, 100}, types.DeflickerSetting{Enable: true, Window: 5})
+	if gains[2] >= 1 || gains[0] <= 1 {
+		t.Fatalf("got %v", gains)
+	}
+	if want := 110.0 / 150; gains[2] != want {
+		t.Fatalf("got %f, want %f", gains[2], want)
+	}
+
+	half := SmoothGains([]float64{100, 100, 150, 100, 100}, types.DeflickerSetting{Enable: true, Window: 5, Strength: 0.5})
+	if want := 1 + 0.5*(110.0/150-1); half[2] != want {
+		t.Fatalf("got %f, want %f", half[2], want)
+	}
+}
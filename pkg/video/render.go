@@ -0,0 +1,217 @@
+package video
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+
+	"plant-shutter-pi/pkg/imaging"
+	"plant-shutter-pi/pkg/types"
+)
+
+const (
+	DefaultFPS = 30
+
+	maxBlend = 30
+)
+
+// RenderOptions 由已保存的照片重新生成视频的参数
+type RenderOptions struct {
+	// FPS 为 0 时为 DefaultFPS
+	FPS int
+	// Width 输出宽度，为 0 时与照片相同，高度按比例计算
+	Width   int
+	Quality int
+
+	Deflicker types.DeflickerSetting
+	// Blend 相邻两张照片之间插入的交叉淡化帧数，为 0 时不插入
+	Blend int
+	// Ramps 变速区间，Start、End 为照片在本次渲染中的序号
+	Ramps []types.SpeedRamp
+}
+
+// Render 将 n 张照片写入 output，load 读取第 i 张照片，progress 汇报已完成的帧数
+func Render(output string, n int, load func(i int) ([]byte, error), o RenderOptions, progress func(done, total int)) error {
+	if n == 0 {
+		return errors.New("no images selected")
+	}
+	if o.FPS <= 0 {
+		o.FPS = DefaultFPS
+	}
+	o.Blend = min(max(o.Blend, 0), maxBlend)
+	positions := Timeline(n, o.Blend, o.Ramps)
+	// 去闪烁需要先遍历一次计算每帧的亮度
+	total := len(positions)
+	if o.Deflicker.Enable {
+		total += n
+	}
+	var gains []float64
+	if o.Deflicker.Enable {
+		lumas := make([]float64, n)
+		for i := range lumas {
+			img, err := decode(load, i)
+			if err != nil {
+				return err
+			}
+			lumas[i] = Luma(img)
+			if progress != nil {
+				progress(i+1, total)
+			}
+		}
+		gains = SmoothGains(lumas, o.Deflicker)
+	}
+
+	r := &renderer{load: load, opts: o, gains: gains}
+	var b *Builder
+	for k, pos := range positions {
+		frame, err := r.frame(pos)
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			w, h, err := FrameSize(frame)
+			if err != nil {
+				return err
+			}
+			if b, err = NewBuilder(output, w, h, o.FPS); err != nil {
+				return err
+			}
+		}
+		if err = b.Add(frame); err != nil {
+			_ = b.Close()
+			return err
+		}
+		if progress != nil {
+			progress(total-len(positions)+k+1, total)
+		}
+	}
+
+	return b.Close()
+}
+
+// Timeline 计算每个输出帧在照片序列中的位置，小数部分表示与下一张照片的混合比例。
+// 正常速度下每张照片占 blend+1 帧，变速区间内按 Speed 加快或放慢。
+func Timeline(n, blend int, ramps []types.SpeedRamp) []float64 {
+	var res []float64
+	last := float64(n - 1)
+	for t := 0.0; t <= last+1e-9; {
+		res = append(res, t)
+		t += speedAt(ramps, int(t)) / float64(blend+1)
+		// 消除累加误差，保证整数位置取到原始照片
+		if r := math.Round(t); math.Abs(t-r) < 1e-6 {
+			t = r
+		}
+	}
+
+	return res
+}
+
+func speedAt(ramps []types.SpeedRamp, i int) float64 {
+	for _, r := range ramps {
+		if i >= r.Start && i < r.End && r.Speed > 0 {
+			return math.Min(20, math.Max(0.05, r.Speed))
+		}
+	}
+
+	return 1
+}
+
+// renderer 缓存最近解码的两张照片，供交叉淡化使用
+type renderer struct {
+	load  func(i int) ([]byte, error)
+	opts  RenderOptions
+	gains []float64
+
+	cache map[int]image.Image
+}
+
+func (r *renderer) frame(pos float64) ([]byte, error) {
+	i := int(pos)
+	f := pos - float64(i)
+	// 不混合时只重复或跳过照片，无需处理的帧直接使用原始数据
+	if r.opts.Blend == 0 || f < 1e-6 {
+		if r.opts.Width <= 0 && r.gains == nil {
+			return r.load(i)
+		}
+		img, err := r.image(i)
+		if err != nil {
+			return nil, err
+		}
+		return imaging.Encode(img, r.opts.Quality)
+	}
+	a, err := r.image(i)
+	if err != nil {
+		return nil, err
+	}
+	b, err := r.image(i + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return imaging.Encode(Blend(a, b, f), r.opts.Quality)
+}
+
+// image 返回缩放并校正后的第 i 张照片
+func (r *renderer) image(i int) (image.Image, error) {
+	if img, ok := r.cache[i]; ok {
+		return img, nil
+	}
+	img, err := decode(r.load, i)
+	if err != nil {
+		return nil, fmt.Errorf("frame %d: %w", i, err)
+	}
+	if r.opts.Width > 0 {
+		b := img.Bounds()
+		w, h := imaging.FitSize(b.Dx(), b.Dy(), r.opts.Width, 0)
+		img = imaging.Resize(img, w, h)
+	}
+	if r.gains != nil && r.gains[i] != 1 {
+		img = ApplyGain(img, r.gains[i])
+	}
+	for k := range r.cache {
+		if k < i-1 {
+			delete(r.cache, k)
+		}
+	}
+	if r.cache == nil {
+		r.cache = make(map[int]image.Image)
+	}
+	r.cache[i] = img
+
+	return img, nil
+}
+
+// Blend 按比例 f(0-1) 混合 a 与 b，b 的尺寸不同时先缩放到 a 的尺寸
+func Blend(a, b image.Image, f float64) *image.RGBA {
+	ra, rb := toRGBA(a), toRGBA(imaging.Resize(b, a.Bounds().Dx(), a.Bounds().Dy()))
+	dst := image.NewRGBA(ra.Rect)
+	wb := uint32(math.Round(f * 256))
+	wa := 256 - wb
+	for i := range dst.Pix {
+		dst.Pix[i] = uint8((uint32(ra.Pix[i])*wa + uint32(rb.Pix[i])*wb) >> 8)
+	}
+
+	return dst
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+
+	return dst
+}
+
+func decode(load func(i int) ([]byte, error), i int) (image.Image, error) {
+	frame, err := load(i)
+	if err != nil {
+		return nil, err
+	}
+
+	return imaging.Decode(frame)
+}
@@ -0,0 +1,27 @@
+package video
+
+import (
+	"testing"
+
+	"plant-shutter-pi/pkg/types"
+)
+
+func TestTimeline(t *testing.T) {
+	if got := Timeline(3, 0, nil); len(got) != 3 || got[2] != 2 {
+		t.Fatalf("got %v", got)
+	}
+	// 每两张照片之间插入 3 帧过渡
+	got := Timeline(3, 3, nil)
+	if len(got) != 9 || got[4] != 1 || got[8] != 2 {
+		t.Fatalf("got %v", got)
+	}
+	// 第 2 张照片慢放到 1/4 速度，不混合时重复 4 次
+	got = Timeline(3, 0, []types.SpeedRamp{{Start: 1, End: 2, Speed: 0.25}})
+	if len(got) != 6 || got[1] != 1 || got[4] != 1.75 || got[5] != 2 {
+		t.Fatalf("got %v", got)
+	}
+	// 2 倍速时跳过照片
+	if got = Timeline(5, 0, []types.SpeedRamp{{End: 5, Speed: 2}}); len(got) != 3 {
+		t.Fatalf("got %v", got)
+	}
+}
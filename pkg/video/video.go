@@ -1,16 +1,22 @@
 package video
 
 import (
+	"bytes"
+	"image/jpeg"
+
 	"github.com/icza/mjpeg"
 )
 
 type Builder struct {
+	path   string
 	width  int
 	height int
 	fps    int
 
 	cnt int
 	aw  mjpeg.AviWriter
+
+	deflicker *Deflicker
 }
 
 func NewBuilder(path string, width, height, fps int) (*Builder, error) {
@@ -20,6 +26,7 @@ func NewBuilder(path string, width, height, fps int) (*Builder, error) {
 	}
 
 	return &Builder{
+		path:   path,
 		width:  width,
 		height: height,
 		fps:    fps,
@@ -27,7 +34,40 @@ func NewBuilder(path string, width, height, fps int) (*Builder, error) {
 	}, nil
 }
 
+// Resume 打开未写满的视频继续添加帧，不支持时返回错误
+func Resume(path string) (*Builder, error) {
+	w, err := openAppender(path)
+	if err != nil {
+		return nil, err
+	}
+	fps := w.a.fps
+	if fps <= 0 {
+		fps = DefaultFPS
+	}
+
+	return &Builder{
+		path:   path,
+		width:  w.a.width,
+		height: w.a.height,
+		fps:    fps,
+		cnt:    len(w.a.frames),
+		aw:     w,
+	}, nil
+}
+
+// SetDeflicker 之后添加的帧先经过去闪烁处理
+func (b *Builder) SetDeflicker(d *Deflicker) {
+	b.deflicker = d
+}
+
 func (b *Builder) Add(frame []byte) error {
+	if b.deflicker != nil {
+		data, err := b.deflicker.Apply(frame)
+		if err != nil {
+			return err
+		}
+		frame = data
+	}
 	err := b.aw.AddFrame(frame)
 	if err != nil {
 		return err
@@ -44,3 +84,25 @@ func (b *Builder) Close() error {
 func (b *Builder) GetCnt() int {
 	return b.cnt
 }
+
+func (b *Builder) Path() string {
+	return b.path
+}
+
+func (b *Builder) Size() (int, int) {
+	return b.width, b.height
+}
+
+func (b *Builder) FPS() int {
+	return b.fps
+}
+
+// FrameSize 读取 JPEG 帧的尺寸
+func FrameSize(frame []byte) (width, height int, err error) {
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(frame))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return cfg.Width, cfg.Height, nil
+}
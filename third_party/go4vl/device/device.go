@@ -22,6 +22,11 @@ type Device struct {
 	requestedBuf v4l2.RequestBuffers
 	streaming    bool
 	output       chan []byte
+	// stop asks the stream loop to exit, done is closed once it has exited
+	stop chan struct{}
+	done chan struct{}
+	// streamErr records why the stream loop ended, read after done is closed
+	streamErr error
 }
 
 // Open creates opens the underlying device at specified path for streaming.
@@ -115,13 +120,16 @@ func Open(path string, options ...Option) (*Device, error) {
 }
 
 // Close closes the underlying device associated with `d` .
+// The device is closed even if stopping the stream fails (e.g. the device is gone).
 func (d *Device) Close() error {
+	var err error
 	if d.streaming {
-		if err := d.Stop(); err != nil {
-			return err
-		}
+		err = d.Stop()
+	}
+	if cerr := v4l2.CloseDevice(d.fd); err == nil {
+		err = cerr
 	}
-	return v4l2.CloseDevice(d.fd)
+	return err
 }
 
 // Name returns the device name (or path)
@@ -358,17 +366,41 @@ func (d *Device) Start(ctx context.Context) error {
 	return nil
 }
 
+// Done returns a channel that is closed when the stream loop exits,
+// either after Stop or because capturing failed (see Err).
+func (d *Device) Done() <-chan struct{} {
+	return d.done
+}
+
+// Err returns the error that ended the stream loop, or nil if the stream
+// is still running or was stopped normally.
+func (d *Device) Err() error {
+	if d.done == nil {
+		return nil
+	}
+	select {
+	case <-d.done:
+		return d.streamErr
+	default:
+		return nil
+	}
+}
+
+// Stop ends the stream loop, waits for it to exit and releases the buffers.
+// It must be called by the owner of the device, never from the stream loop.
 func (d *Device) Stop() error {
 	if !d.streaming {
 		return nil
 	}
+	close(d.stop)
+	<-d.done
+	d.streaming = false
 	if err := v4l2.UnmapMemoryBuffers(d); err != nil {
 		return fmt.Errorf("device: stop: %w", err)
 	}
 	if err := v4l2.StreamOff(d); err != nil {
 		return fmt.Errorf("device: stop: %w", err)
 	}
-	d.streaming = false
 	return nil
 }
 
@@ -388,7 +420,12 @@ func (d *Device) startStreamLoop(ctx context.Context) error {
 		return fmt.Errorf("device: stream on: %w", err)
 	}
 
+	// the loop never stops the device itself: the owner may be closing it concurrently.
+	// Errors are reported through Err and Done, the owner then calls Stop/Close.
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
 	go func() {
+		defer close(d.done)
 		defer close(d.output)
 
 		fd := d.Fd()
@@ -396,6 +433,15 @@ func (d *Device) startStreamLoop(ctx context.Context) error {
 		ioMemType := d.MemIOType()
 		bufType := d.BufferType()
 		waitForRead := v4l2.WaitForRead(d)
+		send := func(frame []byte) bool {
+			select {
+			case d.output <- frame:
+				return true
+			case <-d.stop:
+			case <-ctx.Done():
+			}
+			return false
+		}
 		for {
 			select {
 			// handle stream capture (read from driver)
@@ -405,26 +451,34 @@ func (d *Device) startStreamLoop(ctx context.Context) error {
 					if errors.Is(err, sys.EAGAIN) {
 						continue
 					}
-					panic(fmt.Sprintf("device: stream loop dequeue: %s", err))
+					// device is gone (e.g. unplugged): end the stream instead of crashing the process
+					d.streamErr = fmt.Errorf("device: stream loop dequeue: %w", err)
+					return
 				}
 
 				// copy mapped buffer (copying avoids polluted data from subsequent dequeue ops)
 				if buff.Flags&v4l2.BufFlagMapped != 0 && buff.Flags&v4l2.BufFlagError == 0 {
 					frame = make([]byte, buff.BytesUsed)
 					if n := copy(frame, d.buffers[buff.Index][:buff.BytesUsed]); n == 0 {
-						d.output <- []byte{}
+						if !send([]byte{}) {
+							return
+						}
+					}
+					if !send(frame) {
+						return
 					}
-					d.output <- frame
 					frame = nil
-				} else {
-					d.output <- []byte{}
+				} else if !send([]byte{}) {
+					return
 				}
 
 				if _, err := v4l2.QueueBuffer(fd, ioMemType, bufType, buff.Index); err != nil {
-					panic(fmt.Sprintf("device: stream loop queue: %s: buff: %#v", err, buff))
+					d.streamErr = fmt.Errorf("device: stream loop queue: %w", err)
+					return
 				}
+			case <-d.stop:
+				return
 			case <-ctx.Done():
-				d.Stop()
 				return
 			}
 		}